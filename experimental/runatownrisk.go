@@ -20,7 +20,7 @@ under the License.
 package main
 
 import (
-	"errors"
+	"encoding/base64"
 	"fmt"
 	"strconv"
 	"encoding/json"
@@ -60,6 +60,30 @@ type AllTrades struct{
 	OpenTrades []AnOpenTrade `json:"open_trades"`
 }
 
+type MarblePage struct{
+	Records []Marble `json:"records"`
+	Bookmark string `json:"bookmark"`			//pass back to get the next page, empty on the last page
+}
+
+var maxPageSize = 500							//upper bound on records returned by one page
+
+// chaincode event names, fabric keeps one event per transaction so the last one set wins
+var marbleUserEvent = "marble_user_changed"
+var tradeOpenedEvent = "marble_trade_opened"
+var tradeClosedEvent = "marble_trade_closed"
+
+// the part of the stub events need, *shim.ChaincodeStub satisfies it
+type eventStub interface{
+	SetEvent(name string, payload []byte) error
+}
+
+type MarbleEvent struct{
+	Key string `json:"key"`						//marble name, or the timestamp id of an open trade
+	OldState string `json:"oldstate"`			//owner of a marble, or "open" / "closed" for an open trade
+	NewState string `json:"newstate"`
+	Actor string `json:"actor"`					//user the change was made for
+}
+
 // ============================================================================================================================
 // Main
 // ============================================================================================================================
@@ -78,13 +102,13 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 
 	// Initialize the chaincode
 	Aval, err = strconv.Atoi(args[0])
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be an integer value for asset holding")
 	}
 
 	// Write the state to the ledger
@@ -113,7 +137,9 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 // ============================================================================================================================
 // Run - Our entry point for Invokcations
 // ============================================================================================================================
-func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
+
 	fmt.Println("run is running " + function)
 
 	// Handle different functions
@@ -142,63 +168,149 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 	}
 	fmt.Println("run did not find func: " + function)						//error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 }
 
 // ============================================================================================================================
 // Query - Our entry point for Queries
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
+
 	fmt.Println("query is running " + function)
 
 	// Handle different functions
 	if function == "read" {													//read a variable
 		return t.read(stub, args)
+	} else if function == "list_marbles" {									//one page of marbles
+		return t.list_marbles(stub, args)
 	}
 	fmt.Println("query did not find func: " + function)						//error
 
-	return nil, errors.New("Received unknown function query")
+	return nil, newError(ErrUnknownFunction, "Received unknown function query")
 }
 
 // ============================================================================================================================
 // Read - read a variable from chaincode state
 // ============================================================================================================================
 func (t *SimpleChaincode) read(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	var name, jsonResp string
+	var name string
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting name of the var to query")
 	}
 
 	name = args[0]
 	valAsbytes, err := stub.GetState(name)									//get the var from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(ErrInternal, "Failed to get state for " + name)
 	}
 
 	return valAsbytes, nil													//send it onward
 }
 
+// ============================================================================================================================
+// List Marbles - return one page of marbles from the marble index plus a bookmark for the next page
+// ============================================================================================================================
+func (t *SimpleChaincode) list_marbles(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	var start int
+
+	//     0            1
+	// "page size", "bookmark"
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1 or 2, page size and an optional bookmark")
+	}
+
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a numeric string between 1 and " + strconv.Itoa(maxPageSize))
+	}
+
+	//get the marble index
+	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if err != nil {
+		return nil, newError(ErrInternal, "Failed to get marble index")
+	}
+	var marbleIndex []string
+	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
+
+	if len(args) == 2 && len(args[1]) > 0 {										//bookmark is the last marble of the previous page
+		start, err = resumePosition(args[1], marbleIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := MarblePage{}
+	page.Records = []Marble{}
+	end := start + pageSize
+	if end > len(marbleIndex) {
+		end = len(marbleIndex)
+	}
+	for i := start; i < end; i++ {
+		marbleAsBytes, err := stub.GetState(marbleIndex[i])						//grab this marble
+		if err != nil {
+			return nil, newError(ErrInternal, "Failed to get marble")
+		}
+		res := Marble{}
+		json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
+		page.Records = append(page.Records, res)
+	}
+	if end < len(marbleIndex) {
+		page.Bookmark = base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(end - 1) + ":" + marbleIndex[end - 1]))
+	}
+
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// Resume Position - index position after the marble a bookmark names, deletes between pages shift positions so the name
+//                   is looked up, a bookmarked marble that was deleted itself resumes at its old position
+// ============================================================================================================================
+func resumePosition(bookmark string, marbleIndex []string) (int, error) {
+	raw, err := base64.URLEncoding.DecodeString(bookmark)
+	if err != nil {
+		return 0, argError(ErrInvalidArgs, 1, "Invalid bookmark")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, argError(ErrInvalidArgs, 1, "Invalid bookmark")
+	}
+	position, err := strconv.Atoi(parts[0])
+	if err != nil || position < 0 {
+		return 0, argError(ErrInvalidArgs, 1, "Invalid bookmark")
+	}
+
+	for i := range marbleIndex {
+		if marbleIndex[i] == parts[1] {
+			return i + 1, nil
+		}
+	}
+	if position > len(marbleIndex) {
+		return len(marbleIndex), nil
+	}
+	return position, nil
+}
+
 // ============================================================================================================================
 // Delete - remove a key/value pair from state
 // ============================================================================================================================
 func (t *SimpleChaincode) Delete(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 	
 	name := args[0]
 	err := stub.DelState(name)													//remove the key from chaincode state
 	if err != nil {
-		return nil, errors.New("Failed to delete state")
+		return nil, newError(ErrInternal, "Failed to delete state")
 	}
 
 	//get the marble index
 	marblesAsBytes, err := stub.GetState(marbleIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, newError(ErrInternal, "Failed to get marble index")
 	}
 	var marbleIndex []string
 	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
@@ -229,7 +341,7 @@ func (t *SimpleChaincode) Write(stub *shim.ChaincodeStub, args []string) ([]byte
 	fmt.Println("running write()")
 
 	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, name of the variable and value to set")
 	}
 
 	name = args[0]															//rename for funsies
@@ -250,26 +362,26 @@ func (t *SimpleChaincode) init_marble(stub *shim.ChaincodeStub, args []string) (
 	//   0       1       2     3
 	// "asdf", "blue", "35", "bob"
 	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 4")
 	}
 
 	fmt.Println("- start init marble")
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return nil, errors.New("3rd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return nil, errors.New("4th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 3, "4th argument must be a non-empty string")
 	}
 	
 	size, err := strconv.Atoi(args[2])
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a numeric string")
 	}
 	
 	color := strings.ToLower(args[1])
@@ -284,7 +396,7 @@ func (t *SimpleChaincode) init_marble(stub *shim.ChaincodeStub, args []string) (
 	//get the marble index
 	marblesAsBytes, err := stub.GetState(marbleIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, newError(ErrInternal, "Failed to get marble index")
 	}
 	var marbleIndex []string
 	json.Unmarshal(marblesAsBytes, &marbleIndex)							//un stringify it aka JSON.parse()
@@ -308,17 +420,18 @@ func (t *SimpleChaincode) set_user(stub *shim.ChaincodeStub, args []string) ([]b
 	//   0       1
 	// "name", "bob"
 	if len(args) < 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2")
 	}
 	
 	fmt.Println("- start set user")
 	fmt.Println(args[0] + " - " + args[1])
 	marbleAsBytes, err := stub.GetState(args[0])
 	if err != nil {
-		return nil, errors.New("Failed to get thing")
+		return nil, newError(ErrInternal, "Failed to get marble " + args[0])
 	}
 	res := Marble{}
 	json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
+	oldUser := res.User
 	res.User = args[1]														//change the user
 	
 	jsonAsBytes, _ := json.Marshal(res)
@@ -326,6 +439,11 @@ func (t *SimpleChaincode) set_user(stub *shim.ChaincodeStub, args []string) ([]b
 	if err != nil {
 		return nil, err
 	}
+
+	err = setMarbleEvent(stub, marbleUserEvent, args[0], oldUser, res.User, res.User)	//no caller identity here, report the new owner
+	if err != nil {
+		return nil, err
+	}
 	
 	fmt.Println("- end set user")
 	return nil, nil
@@ -342,15 +460,15 @@ func (t *SimpleChaincode) open_trade(stub *shim.ChaincodeStub, args []string) ([
 	//	0        1      2     3      4      5       6
 	//["bob", "blue", "16", "red", "16"] *"blue", "35*
 	if len(args) < 5 {
-		return nil, errors.New("Incorrect number of arguments. Expecting like 5?")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting at least 5, user, wanted color and size, then color and size of each marble offered")
 	}
 	if len(args)%2 == 0{
-		return nil, errors.New("Incorrect number of arguments. Expecting an odd number")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an odd number")
 	}
 
 	size1, err := strconv.Atoi(args[2])
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a numeric string")
 	}
 
 	open := AnOpenTrade{}
@@ -365,9 +483,9 @@ func (t *SimpleChaincode) open_trade(stub *shim.ChaincodeStub, args []string) ([
 	for i:=3; i < len(args); i++ {												//create and append each willing trade
 		will_size, err = strconv.Atoi(args[i + 1])
 		if err != nil {
-			msg := "is not a numeric string " + args[i + 1]
+			msg := "size " + args[i + 1] + " is not a numeric string"
 			fmt.Println(msg)
-			return nil, errors.New(msg)
+			return nil, argError(ErrInvalidArgs, i + 1, msg)
 		}
 		
 		trade_away = Description{}
@@ -385,7 +503,7 @@ func (t *SimpleChaincode) open_trade(stub *shim.ChaincodeStub, args []string) ([
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, newError(ErrInternal, "Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
@@ -397,6 +515,11 @@ func (t *SimpleChaincode) open_trade(stub *shim.ChaincodeStub, args []string) ([
 	if err != nil {
 		return nil, err
 	}
+
+	err = setMarbleEvent(stub, tradeOpenedEvent, strconv.FormatInt(open.Timestamp, 10), "", "open", open.User)
+	if err != nil {
+		return nil, err
+	}
 	fmt.Println("- end open trade")
 	return nil, nil
 }
@@ -410,24 +533,24 @@ func (t *SimpleChaincode) perform_trade(stub *shim.ChaincodeStub, args []string)
 	//	0		1					2					3				4					5
 	//[data.id, data.closer.user, data.closer.name, data.opener.user, data.opener.color, data.opener.size]
 	if len(args) < 6 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 6")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 6")
 	}
 	
 	fmt.Println("- start close trade")
 	timestamp, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
-		return nil, errors.New("1st argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a numeric string")
 	}
 	
 	size, err := strconv.Atoi(args[5])
 	if err != nil {
-		return nil, errors.New("6th argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 5, "6th argument must be a numeric string")
 	}
 	
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, newError(ErrInternal, "Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)															//un stringify it aka JSON.parse()
@@ -440,7 +563,7 @@ func (t *SimpleChaincode) perform_trade(stub *shim.ChaincodeStub, args []string)
 			
 			marbleAsBytes, err := stub.GetState(args[2])
 			if err != nil {
-				return nil, errors.New("Failed to get thing")
+				return nil, newError(ErrInternal, "Failed to get marble " + args[2])
 			}
 			closersMarble := Marble{}
 			json.Unmarshal(marbleAsBytes, &closersMarble)											//un stringify it aka JSON.parse()
@@ -449,7 +572,7 @@ func (t *SimpleChaincode) perform_trade(stub *shim.ChaincodeStub, args []string)
 			if closersMarble.Color != trades.OpenTrades[i].Want.Color || closersMarble.Size != trades.OpenTrades[i].Want.Size {
 				msg := "marble in input does not meet trade requriements"
 				fmt.Println(msg)
-				return nil, errors.New(msg)
+				return nil, newError(ErrRejected, msg)
 			}
 			
 			marble, e := findMarble4Trade(stub, trades.OpenTrades[i].User, args[4], size)			//find a marble that is suitable from opener
@@ -465,6 +588,11 @@ func (t *SimpleChaincode) perform_trade(stub *shim.ChaincodeStub, args []string)
 				if err != nil {
 					return nil, err
 				}
+
+				err = setMarbleEvent(stub, tradeClosedEvent, args[0], "open", "closed", args[1])	//replaces the set_user events above
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -472,6 +600,19 @@ func (t *SimpleChaincode) perform_trade(stub *shim.ChaincodeStub, args []string)
 	return nil, nil
 }
 
+// ============================================================================================================================
+// setMarbleEvent - set the chaincode event of the transaction
+// ============================================================================================================================
+func setMarbleEvent(stub eventStub, name string, key string, oldState string, newState string, actor string) error {
+	event := MarbleEvent{}
+	event.Key = key
+	event.OldState = oldState
+	event.NewState = newState
+	event.Actor = actor
+	jsonAsBytes, _ := json.Marshal(event)
+	return stub.SetEvent(name, jsonAsBytes)
+}
+
 // ============================================================================================================================
 // findMarble4Trade - look for a matching marble that this user owns and return it
 // ============================================================================================================================
@@ -483,7 +624,7 @@ func findMarble4Trade(stub *shim.ChaincodeStub, user string, color string, size
 	//get the marble index
 	marblesAsBytes, err := stub.GetState(marbleIndexStr)
 	if err != nil {
-		return fail, errors.New("Failed to get marble index")
+		return fail, newError(ErrInternal, "Failed to get marble index")
 	}
 	var marbleIndex []string
 	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
@@ -493,7 +634,7 @@ func findMarble4Trade(stub *shim.ChaincodeStub, user string, color string, size
 
 		marbleAsBytes, err := stub.GetState(marbleIndex[i])						//grab this marble
 		if err != nil {
-			return fail, errors.New("Failed to get marble")
+			return fail, newError(ErrInternal, "Failed to get marble")
 		}
 		res := Marble{}
 		json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
@@ -508,7 +649,7 @@ func findMarble4Trade(stub *shim.ChaincodeStub, user string, color string, size
 	}
 	
 	fmt.Println("- end find marble 4 trade - error")
-	return fail, errors.New("Did not find marble to use in this trade")
+	return fail, newError(ErrNotFound, "Did not find marble to use in this trade")
 }
 
 // ============================================================================================================================
@@ -527,19 +668,19 @@ func (t *SimpleChaincode) remove_trade(stub *shim.ChaincodeStub, args []string)
 	//	0
 	//[data.id]
 	if len(args) < 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 	
 	fmt.Println("- start remove trade")
 	timestamp, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
-		return nil, errors.New("1st argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a numeric string")
 	}
 	
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, newError(ErrInternal, "Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)																//un stringify it aka JSON.parse()
@@ -572,7 +713,7 @@ func cleanTrades(stub *shim.ChaincodeStub)(err error){
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return errors.New("Failed to get opentrades")
+		return newError(ErrInternal, "Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)																		//un stringify it aka JSON.parse()
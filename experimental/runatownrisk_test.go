@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+// eventRecorder keeps the event a function set, in place of a stub
+type eventRecorder struct{
+	name string
+	payload []byte
+}
+
+func (r *eventRecorder) SetEvent(name string, payload []byte) error {
+	r.name = name
+	r.payload = payload
+	return nil
+}
+
+func TestSetMarbleEvent(t *testing.T) {
+	tests := []struct{
+		name string
+		key string
+		oldState string
+		newState string
+		actor string
+		want string
+	}{
+		{marbleUserEvent, "marble1", "bob", "leroy", "leroy", `{"key":"marble1","oldstate":"bob","newstate":"leroy","actor":"leroy"}`},
+		{tradeOpenedEvent, "1476403200", "", "open", "bob", `{"key":"1476403200","oldstate":"","newstate":"open","actor":"bob"}`},
+		{tradeClosedEvent, "1476403200", "open", "closed", "leroy", `{"key":"1476403200","oldstate":"open","newstate":"closed","actor":"leroy"}`},
+	}
+	for _, test := range tests {
+		recorder := &eventRecorder{}
+		err := setMarbleEvent(recorder, test.name, test.key, test.oldState, test.newState, test.actor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorder.name != test.name {
+			t.Errorf("event name is %s, want %s", recorder.name, test.name)
+		}
+		if string(recorder.payload) != test.want {
+			t.Errorf("payload is %s, want %s", recorder.payload, test.want)
+		}
+	}
+}
@@ -20,7 +20,6 @@ under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"encoding/json"
@@ -51,13 +50,13 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 
 	// Initialize the chaincode
 	Aval, err = strconv.Atoi(args[0])
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be an integer value for asset holding")
 	}
 
 	// Write the state to the ledger
@@ -79,7 +78,9 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 // ============================================================================================================================
 // Run - Our entry point
 // ============================================================================================================================
-func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
+
 	fmt.Println("run is running " + function)
 
 	// Handle different functions
@@ -96,7 +97,7 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 	}
 	fmt.Println("run did not find func: " + function)						//error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 }
 
 // ============================================================================================================================
@@ -104,19 +105,19 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 // ============================================================================================================================
 func (t *SimpleChaincode) Delete(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 	
 	name := args[0]
 	err := stub.DelState(name)													//remove the key from chaincode state
 	if err != nil {
-		return nil, errors.New("Failed to delete state")
+		return nil, newError(ErrInternal, "Failed to delete state")
 	}
 
 	//get the marble index
 	marblesAsBytes, err := stub.GetState(marbleIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, newError(ErrInternal, "Failed to get marble index")
 	}
 	var marbleIndex []string
 	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
@@ -141,22 +142,22 @@ func (t *SimpleChaincode) Delete(stub *shim.ChaincodeStub, args []string) ([]byt
 // ============================================================================================================================
 // Query - read a variable from chaincode state - (aka read)
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
+
 	if function != "query" {
-		return nil, errors.New("Invalid query function name. Expecting \"query\"")
+		return nil, newError(ErrUnknownFunction, "Invalid query function name. Expecting \"query\"")
 	}
-	var name, jsonResp string
-	var err error
+	var name string
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the person to query")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting name of the person to query")
 	}
 
 	name = args[0]
 	valAsbytes, err := stub.GetState(name)									//get the var from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(ErrInternal, "Failed to get state for " + name)
 	}
 
 	return valAsbytes, nil													//send it onward
@@ -178,7 +179,7 @@ func (t *SimpleChaincode) Write(stub *shim.ChaincodeStub, args []string) ([]byte
 	fmt.Println("running write()")
 
 	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, name of the variable and value to set")
 	}
 
 	name = args[0]															//rename for funsies
@@ -199,26 +200,26 @@ func (t *SimpleChaincode) init_marble(stub *shim.ChaincodeStub, args []string) (
 	//   0       1       2     3
 	// "asdf", "blue", "35", "bob"
 	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 4")
 	}
 
 	fmt.Println("- start init marble")
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return nil, errors.New("3rd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return nil, errors.New("4th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 3, "4th argument must be a non-empty string")
 	}
 	
 	size, err := strconv.Atoi(args[2])
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a numeric string")
 	}
 	
 	color := strings.ToLower(args[1])
@@ -233,7 +234,7 @@ func (t *SimpleChaincode) init_marble(stub *shim.ChaincodeStub, args []string) (
 	//get the marble index
 	marblesAsBytes, err := stub.GetState(marbleIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, newError(ErrInternal, "Failed to get marble index")
 	}
 	var marbleIndex []string
 	json.Unmarshal(marblesAsBytes, &marbleIndex)							//un stringify it aka JSON.parse()
@@ -257,14 +258,14 @@ func (t *SimpleChaincode) set_user(stub *shim.ChaincodeStub, args []string) ([]b
 	//   0       1
 	// "name", "bob"
 	if len(args) < 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2")
 	}
 	
 	fmt.Println("- start set user")
 	fmt.Println(args[0] + " - " + args[1])
 	marbleAsBytes, err := stub.GetState(args[0])
 	if err != nil {
-		return nil, errors.New("Failed to get thing")
+		return nil, newError(ErrInternal, "Failed to get marble " + args[0])
 	}
 	res := Marble{}
 	json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
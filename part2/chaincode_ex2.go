@@ -20,7 +20,6 @@ under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"encoding/json"
@@ -58,13 +57,13 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1")
 	}
 
 	// Initialize the chaincode
 	Aval, err = strconv.Atoi(args[0])
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be an integer value for asset holding")
 	}
 
 	// Write the state to the ledger
@@ -86,7 +85,8 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 // ============================================================================================================================
 // Run - Our entry point
 // ============================================================================================================================
-func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
 
 	fmt.Println("ruslan: run is running " + function)
 
@@ -107,28 +107,28 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 
 	fmt.Println("run did not find func: " + function)						// error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 }
 
 // ============================================================================================================================
 // Query - read a variable from chaincode state - (aka read)
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
+
 	if function != "query" {
-		return nil, errors.New("Invalid query function name. Expecting \"query\"")
+		return nil, newError(ErrUnknownFunction, "Invalid query function name. Expecting \"query\"")
 	}
-	var security, jsonResp string
-	var err error
+	var security string
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting security of the trade to query")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting security of the trade to query")
 	}
 
 	security = args[0]
 	valAsbytes, err := stub.GetState(security)									//get the var from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for security " + security + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(ErrInternal, "Failed to get state for security " + security)
 	}
 
 	return valAsbytes, nil													//send it onward
@@ -169,42 +169,42 @@ func (t *SimpleChaincode) init_trade(stub *shim.ChaincodeStub, args []string) ([
 	var err error
 
 	if len(args) != 11 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 11")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 11")
 	}
 
 	fmt.Println("- start init trade")
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return nil, errors.New("3rd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return nil, errors.New("4th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 3, "4th argument must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
-		return nil, errors.New("5th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 4, "5th argument must be a non-empty string")
 	}
 	if len(args[5]) <= 0 {
-		return nil, errors.New("6th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 5, "6th argument must be a non-empty string")
 	}
 	if len(args[6]) <= 0 {
-		return nil, errors.New("7th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 6, "7th argument must be a non-empty string")
 	}
 	if len(args[7]) <= 0 {
-		return nil, errors.New("8th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 7, "8th argument must be a non-empty string")
 	}
 	if len(args[8]) <= 0 {
-		return nil, errors.New("9th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 8, "9th argument must be a non-empty string")
 	}
 	if len(args[9]) <= 0 {
-		return nil, errors.New("10th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 9, "10th argument must be a non-empty string")
 	}
 	if len(args[10]) <= 0 {
-		return nil, errors.New("11th argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 10, "11th argument must be a non-empty string")
 	}
 	
 	// TradeDate string `json:"tradedate"`
@@ -226,7 +226,7 @@ func (t *SimpleChaincode) init_trade(stub *shim.ChaincodeStub, args []string) ([
 	quantity, err := strconv.Atoi(args[3])
 
 	if err != nil {
-		return nil, errors.New("4th argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 3, "4th argument must be a numeric string")
 	}
 
 	security := strings.ToLower(args[4])
@@ -239,13 +239,13 @@ func (t *SimpleChaincode) init_trade(stub *shim.ChaincodeStub, args []string) ([
 	settled, err := strconv.Atoi(args[9])
 
 	if err != nil {
-		return nil, errors.New("10th argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 9, "10th argument must be a numeric string")
 	}
 
 	needsrevision, err := strconv.Atoi(args[10])
 
 	if err != nil {
-		return nil, errors.New("11th argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 10, "11th argument must be a numeric string")
 	}
 
 	str := `{"tradedate": "` + tradedate + `", "valuedate": "` + valuedate + `", "operation": "` + operation + `", "quantity": ` + quantity + `, "security": "` + security + `", "price": "` + price + `", "counterparty": "` + counterparty + `", "user": "` + user + `", "timestamp": "` + timestamp + `", "settled": "` + settled + `", "needsrevision": "` + needsrevision + `"}`
@@ -260,7 +260,7 @@ func (t *SimpleChaincode) init_trade(stub *shim.ChaincodeStub, args []string) ([
 	tradesAsBytes, err := stub.GetState(tradeIndexStr)
 
 	if err != nil {
-		return nil, errors.New("Failed to get trade index")
+		return nil, newError(ErrInternal, "Failed to get trade index")
 	}
 
 	var tradeIndex []string
@@ -287,14 +287,14 @@ func (t *SimpleChaincode) set_user(stub *shim.ChaincodeStub, args []string) ([]b
 	//   0       1
 	// "name", "bob"
 	if len(args) < 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2")
 	}
 	
 	fmt.Println("- start set user")
 	fmt.Println(args[0] + " - " + args[1])
 	marbleAsBytes, err := stub.GetState(args[0])
 	if err != nil {
-		return nil, errors.New("Failed to get thing")
+		return nil, newError(ErrInternal, "Failed to get trade " + args[0])
 	}
 	res := Marble{}
 	json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
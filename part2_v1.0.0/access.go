@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var userPrefix = "_user_"						//prefix for the key/value that stores a user's roles
+var userIndexStr = "_userindex"					//name for the key/value that will store a list of all registered users
+
+// Roles a registered user can hold
+const (
+	RoleTrader       = "trader"
+	RoleMiddleOffice = "middle_office"
+	RoleBackOffice   = "back_office"
+	RoleApprover     = "approver"
+	RoleAdmin        = "admin"
+)
+
+var allRoles = []string{RoleTrader, RoleMiddleOffice, RoleBackOffice, RoleApprover, RoleAdmin}
+
+// permissions lists the roles allowed to invoke each function, every function Run and Invoke dispatch must be listed
+// and names missing from the map are unknown functions
+var permissions = map[string][]string{
+	"init":                    {RoleAdmin},
+	"write":                   {RoleAdmin},
+	"create_and_submit_trade": {RoleTrader},
+	"ingest_fix":              {RoleTrader},
+	"import_trades":           {RoleTrader},
+	"mark_revision_needed":    {RoleMiddleOffice},
+	"mark_revised":            {RoleTrader},
+	"enrich_trade":            {RoleBackOffice},
+	"approve_settlement":      {RoleApprover},
+	"net_settlements":         {RoleApprover},
+	"cancel_trade":            {RoleTrader, RoleMiddleOffice},
+	"match_trade":             {RoleTrader, RoleMiddleOffice},
+	"set_user_party":          {RoleAdmin},
+	"clear_all_trades":        {RoleAdmin},
+	"archive_trades":          {RoleAdmin},
+	"migrate_records":         {RoleAdmin},
+	"set_config":              {RoleAdmin},
+	"add_holiday":             {RoleAdmin},
+	"remove_holiday":          {RoleAdmin},
+	"add_counterparty":        {RoleAdmin},
+	"update_counterparty":     {RoleAdmin},
+	"set_counterparty_status": {RoleAdmin},
+	"add_security":            {RoleAdmin},
+	"update_security":         {RoleAdmin},
+	"register_user":           {RoleAdmin},
+	"remove_user":             {RoleAdmin},
+}
+
+// retiredFunctions tells clients still calling a removed function what replaced it, whatever roles they hold
+var retiredFunctions = map[string]string{
+	"enrich_and_settle": "enrich_and_settle was replaced by enrich_trade followed by approve_settlement from a different user",
+}
+
+type UserRecord struct {
+	ID string `json:"id"`							// common name of the user's certificate, lowercase
+	Roles []string `json:"roles"`
+	Party string `json:"party,omitempty"`			// counterparty id of the firm the user books trades for
+}
+
+// ============================================================================================================================
+// callerID - identity of the caller, the common name of the certificate that signed the transaction
+// ============================================================================================================================
+func callerID(stub *shim.ChaincodeStub) (string, error) {
+	certAsBytes, err := stub.GetCallerCertificate()
+	if err != nil || len(certAsBytes) == 0 {
+		return "", newError(ErrUnauthorized, "Failed to get the caller certificate")
+	}
+
+	block, _ := pem.Decode(certAsBytes)								// accept PEM as well as raw DER
+	if block != nil {
+		certAsBytes = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(certAsBytes)
+	if err != nil {
+		return "", newError(ErrUnauthorized, "Failed to parse the caller certificate")
+	}
+	if len(cert.Subject.CommonName) == 0 {
+		return "", newError(ErrUnauthorized, "Caller certificate has no common name")
+	}
+	return strings.ToLower(cert.Subject.CommonName), nil
+}
+
+// ============================================================================================================================
+// getUserRecord - read a registered user, error if the user is unknown
+// ============================================================================================================================
+func getUserRecord(stub *shim.ChaincodeStub, id string) (UserRecord, error) {
+	var user UserRecord
+
+	userAsBytes, err := stub.GetState(userPrefix + id)
+	if err != nil {
+		return user, newError(ErrInternal, "Failed to get user " + id)
+	}
+	if len(userAsBytes) == 0 {
+		return user, newError(ErrNotFound, "Unknown user " + id)
+	}
+
+	json.Unmarshal(userAsBytes, &user)								// un stringify it aka JSON.parse()
+	return user, nil
+}
+
+// ============================================================================================================================
+// hasRole - true if the user holds one of the roles
+// ============================================================================================================================
+func (u UserRecord) hasRole(roles []string) bool {
+	for _, held := range u.Roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// validRole - true for the roles listed in allRoles
+// ============================================================================================================================
+func validRole(role string) bool {
+	for _, known := range allRoles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// authorize - check the caller holds a role the permission map allows for the function
+//             unknown and retired functions are reported as such before the caller is looked at
+// ============================================================================================================================
+func authorize(stub *shim.ChaincodeStub, function string) error {
+	if replacement, retired := retiredFunctions[function]; retired {
+		return newError(ErrUnknownFunction, replacement)
+	}
+	roles, ok := permissions[function]
+	if !ok {
+		return newError(ErrUnknownFunction, "Received unknown function invocation " + function)
+	}
+
+	id, err := callerID(stub)
+	if err != nil {
+		return err
+	}
+
+	user, err := getUserRecord(stub, id)
+	if err != nil {
+		return err
+	}
+	if !user.hasRole(roles) {
+		return newError(ErrUnauthorized, "User " + id + " is not allowed to invoke " + function + ", requires one of: " + strings.Join(roles, ", "))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkActor - a user argument must name the caller, nobody can act on another user's behalf
+// ============================================================================================================================
+func checkActor(stub *shim.ChaincodeStub, user string) error {
+	id, err := callerID(stub)
+	if err != nil {
+		return err
+	}
+	if user != id {
+		return newError(ErrUnauthorized, "User " + user + " does not match the caller " + id)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkTradeOwner - only the trader who booked a trade may change it, unless the user holds one of the elevated roles
+// ============================================================================================================================
+func checkTradeOwner(stub *shim.ChaincodeStub, user string, key string, trade Trade, elevated []string) error {
+	if tradeOwner(trade) == user {
+		return nil
+	}
+	record, err := getUserRecord(stub, user)
+	if err != nil {
+		return err
+	}
+	if len(elevated) > 0 && record.hasRole(elevated) {
+		return nil
+	}
+	return newError(ErrUnauthorized, "Trade " + key + " was booked by " + tradeOwner(trade) + ", user " + user + " cannot change it")
+}
+
+// ============================================================================================================================
+// putUserRecord - register a user and their roles, replacing any roles held before
+// ============================================================================================================================
+func putUserRecord(stub *shim.ChaincodeStub, id string, roles []string) error {
+	user := UserRecord{}
+	user.ID = strings.ToLower(id)
+
+	if len(user.ID) == 0 {
+		return newError(ErrInvalidArgs, "User id must be a non-empty string")
+	}
+	if len(roles) == 0 {
+		return newError(ErrInvalidArgs, "User " + user.ID + " must have at least 1 role")
+	}
+	for _, role := range roles {
+		role = strings.ToLower(role)
+		if !validRole(role) {
+			return newError(ErrInvalidArgs, "Unknown role " + role + ", expecting one of: " + strings.Join(allRoles, ", "))
+		}
+		user.Roles = append(user.Roles, role)
+	}
+
+	existing, err := getUserRecord(stub, user.ID)
+	exists := err == nil
+	user.Party = existing.Party										// roles are replaced, the party is kept
+
+	jsonAsBytes, _ := json.Marshal(user)
+	err = stub.PutState(userPrefix + user.ID, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		userIndex, err := getIndex(stub, userIndexStr)
+		if err != nil {
+			return err
+		}
+		return putIndex(stub, userIndexStr, append(userIndex, user.ID))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// register_user - register a user, or replace a registered user's roles
+// ============================================================================================================================
+func (t *SimpleChaincode) register_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//   0        1          2
+	// "bob", "trader", "admin", ...
+	if len(args) < 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting at least 2, user and roles")
+	}
+
+	fmt.Println("- start register_user")
+
+	err := putUserRecord(stub, args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end register_user")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// remove_user - remove a user from the registry
+// ============================================================================================================================
+func (t *SimpleChaincode) remove_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, the user")
+	}
+
+	fmt.Println("- start remove_user")
+
+	id := strings.ToLower(args[0])
+	_, err := getUserRecord(stub, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(userPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+
+	userIndex, err := getIndex(stub, userIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	err = putIndex(stub, userIndexStr, removeKey(userIndex, id))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end remove_user")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// list_users - return every registered user and their roles
+// ============================================================================================================================
+func (t *SimpleChaincode) list_users(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	userIndex, err := getIndex(stub, userIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []UserRecord{}
+	for _, id := range userIndex {
+		user, err := getUserRecord(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return json.Marshal(users)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var holidayIndexStr = "_holidays"				//name for the key/value that stores the sorted list of non business days
+var dateLayout = "2006-01-02"					//trade and value dates are ISO 8601 calendar dates
+
+// ============================================================================================================================
+// parseDate - parse an ISO date such as 2016-10-14
+// ============================================================================================================================
+func parseDate(name string, value string) (time.Time, error) {
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return date, newError(ErrInvalidArgs, name + " must be an ISO date, e.g. 2016-10-14")
+	}
+	return date, nil
+}
+
+// ============================================================================================================================
+// resolveValueDate - derive the value date from the trade date when it is given as "auto", the configured settlement cycle,
+//                    or "t+N", skipping weekends and ledger holidays, arg is the argument the value date came in
+// ============================================================================================================================
+func resolveValueDate(stub *shim.ChaincodeStub, trade *Trade, arg int) error {
+	var days int
+
+	if trade.ValueDate == "auto" {
+		config, err := getConfig(stub)
+		if err != nil {
+			return err
+		}
+		days = config.SettlementCycle
+	} else if strings.HasPrefix(trade.ValueDate, "t+") {
+		var err error
+		days, err = strconv.Atoi(trade.ValueDate[2:])
+		if err != nil || days < 0 || days > maxSettlementCycle {
+			return argError(ErrInvalidArgs, arg, "valuedate t+N must have a whole number of business days between 0 and " + strconv.Itoa(maxSettlementCycle))
+		}
+	} else {
+		return nil														// an explicit date, checked by validateTrade
+	}
+
+	tradeDate, err := parseDate("tradedate", trade.TradeDate)
+	if err != nil {
+		return err
+	}
+
+	holidays, err := getIndex(stub, holidayIndexStr)
+	if err != nil {
+		return err
+	}
+
+	trade.ValueDate = addBusinessDays(tradeDate, days, holidays).Format(dateLayout)
+	return nil
+}
+
+// ============================================================================================================================
+// addBusinessDays - the date n business days after start, holidays must be sorted
+// ============================================================================================================================
+func addBusinessDays(start time.Time, n int, holidays []string) time.Time {
+	date := start
+	for n > 0 {
+		date = date.AddDate(0, 0, 1)
+		if isBusinessDay(date, holidays) {
+			n--
+		}
+	}
+	return date
+}
+
+// ============================================================================================================================
+// isBusinessDay - false on weekends and holidays
+// ============================================================================================================================
+func isBusinessDay(date time.Time, holidays []string) bool {
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return false
+	}
+	day := date.Format(dateLayout)
+	i := sort.SearchStrings(holidays, day)
+	return i >= len(holidays) || holidays[i] != day
+}
+
+// ============================================================================================================================
+// add_holiday - add one or more ISO dates to the holiday calendar
+// ============================================================================================================================
+func (t *SimpleChaincode) add_holiday(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) < 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting at least 1 date")
+	}
+
+	fmt.Println("- start add_holiday")
+
+	holidays, err := getIndex(stub, holidayIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		date, err := parseDate("holiday", arg)
+		if err != nil {
+			return nil, err
+		}
+
+		day := date.Format(dateLayout)
+		i := sort.SearchStrings(holidays, day)
+		if i < len(holidays) && holidays[i] == day {
+			continue													// already a holiday
+		}
+		holidays = append(holidays, "")
+		copy(holidays[i+1:], holidays[i:])
+		holidays[i] = day
+	}
+
+	err = putIndex(stub, holidayIndexStr, holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end add_holiday")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// remove_holiday - remove one or more ISO dates from the holiday calendar
+// ============================================================================================================================
+func (t *SimpleChaincode) remove_holiday(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) < 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting at least 1 date")
+	}
+
+	fmt.Println("- start remove_holiday")
+
+	holidays, err := getIndex(stub, holidayIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		date, err := parseDate("holiday", arg)
+		if err != nil {
+			return nil, err
+		}
+		holidays = removeKey(holidays, date.Format(dateLayout))
+	}
+
+	err = putIndex(stub, holidayIndexStr, holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end remove_holiday")
+	return nil, nil
+}
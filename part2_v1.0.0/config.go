@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var configStr = "_config"						//name for the key/value that stores the chaincode settings
+var maxSettlementCycle = 30						//upper bound in business days on the settlement cycle and on t+N value dates
+
+type Config struct {
+	PriceScale int `json:"pricescale"`			// decimal places allowed in prices
+	AmountScale int `json:"amountscale"`		// decimal places of cash amounts, consideration is rounded half to even
+	SettlementCycle int `json:"settlementcycle"`	// business days from trade date to value date when valuedate is "auto"
+	RetentionDays int `json:"retentiondays"`		// days settled and cancelled trades stay active before archive_trades moves them
+}
+
+// ============================================================================================================================
+// defaultConfig - settings used until set_config has been called
+// ============================================================================================================================
+func defaultConfig() Config {
+	config := Config{}
+	config.PriceScale = 6
+	config.AmountScale = 2
+	config.SettlementCycle = 2
+	config.RetentionDays = 365
+	return config
+}
+
+// ============================================================================================================================
+// getConfig - read the chaincode settings, defaults for anything never set
+// ============================================================================================================================
+func getConfig(stub *shim.ChaincodeStub) (Config, error) {
+	config := defaultConfig()
+
+	configAsBytes, err := stub.GetState(configStr)
+	if err != nil {
+		return config, newError(ErrInternal, "Failed to get value for key " + configStr)
+	}
+	if len(configAsBytes) > 0 {
+		json.Unmarshal(configAsBytes, &config)						// stored fields override the defaults
+	}
+	return config, nil
+}
+
+// ============================================================================================================================
+// validateConfig - check settings before they are stored
+// ============================================================================================================================
+func validateConfig(config Config) error {
+	if config.PriceScale < 0 || config.PriceScale > 18 {
+		return newError(ErrInvalidArgs, "pricescale must be between 0 and 18")
+	}
+	if config.AmountScale < 0 || config.AmountScale > 18 {
+		return newError(ErrInvalidArgs, "amountscale must be between 0 and 18")
+	}
+	if config.SettlementCycle < 0 || config.SettlementCycle > maxSettlementCycle {
+		return newError(ErrInvalidArgs, "settlementcycle must be between 0 and " + strconv.Itoa(maxSettlementCycle))
+	}
+	if config.RetentionDays < 1 || config.RetentionDays > 3650 {
+		return newError(ErrInvalidArgs, "retentiondays must be between 1 and 3650")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// set_config - change chaincode settings, fields missing from the JSON argument keep their current value
+// ============================================================================================================================
+func (t *SimpleChaincode) set_config(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, a JSON object of settings")
+	}
+
+	fmt.Println("- start set_config")
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	current := config
+
+	err = json.Unmarshal([]byte(args[0]), &config)
+	if err != nil {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a JSON object of settings, e.g. {\"pricescale\": 6}")
+	}
+
+	err = validateConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// stored prices, considerations and net cash are parsed at the configured scale, fewer places would reject them
+	if config.PriceScale < current.PriceScale {
+		return nil, argError(ErrInvalidArgs, 0, "pricescale cannot be lowered from " + strconv.Itoa(current.PriceScale) + ", stored prices would no longer parse")
+	}
+	if config.AmountScale < current.AmountScale {
+		return nil, argError(ErrInvalidArgs, 0, "amountscale cannot be lowered from " + strconv.Itoa(current.AmountScale) + ", stored amounts would no longer parse")
+	}
+
+	jsonAsBytes, _ := json.Marshal(config)
+	err = stub.PutState(configStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set_config")
+	return nil, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var counterpartyPrefix = "_counterparty_"			//prefix for the key/value that stores a counterparty
+var counterpartyIndexStr = "_counterpartyindex"		//name for the key/value that will store a list of all counterparty ids
+
+// Counterparty statuses, only active counterparties can be traded with
+const (
+	CounterpartyActive    = "active"
+	CounterpartySuspended = "suspended"
+)
+
+type SettlementDetails struct {
+	Account string `json:"account"`
+	Custodian string `json:"custodian"`
+	PlaceOfSettlement string `json:"placeofsettlement"`
+	SSIReference string `json:"ssireference"`
+}
+
+type Counterparty struct {
+	ID string `json:"id"`								// short code used on trades, lowercase
+	LegalName string `json:"legalname"`
+	LEI string `json:"lei"`							// ISO 17442 legal entity identifier
+	Status string `json:"status"`
+	Settlement SettlementDetails `json:"settlement"`
+}
+
+// ============================================================================================================================
+// getCounterparty - read a counterparty from the registry, error if it is unknown
+// ============================================================================================================================
+func getCounterparty(stub stateReader, id string) (Counterparty, error) {
+	var counterparty Counterparty
+
+	counterpartyAsBytes, err := stub.GetState(counterpartyPrefix + id)
+	if err != nil {
+		return counterparty, newError(ErrInternal, "Failed to get counterparty " + id)
+	}
+	if len(counterpartyAsBytes) == 0 {
+		return counterparty, newError(ErrNotFound, "Unknown counterparty " + id)
+	}
+
+	json.Unmarshal(counterpartyAsBytes, &counterparty)				// un stringify it aka JSON.parse()
+	return counterparty, nil
+}
+
+// ============================================================================================================================
+// checkCounterparty - a trade may only be booked against a known, active counterparty
+// ============================================================================================================================
+func checkCounterparty(stub *shim.ChaincodeStub, id string) error {
+	counterparty, err := getCounterparty(stub, id)
+	if err != nil {
+		return err
+	}
+	if counterparty.Status != CounterpartyActive {
+		return newError(ErrRejected, "Counterparty " + id + " is " + counterparty.Status)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// parseCounterparty - un stringify and validate a counterparty argument
+// ============================================================================================================================
+func parseCounterparty(arg string) (Counterparty, error) {
+	var counterparty Counterparty
+
+	err := json.Unmarshal([]byte(arg), &counterparty)
+	if err != nil {
+		return counterparty, newError(ErrInvalidArgs, "Counterparty must be a JSON object with id, legalname, lei, status and settlement")
+	}
+
+	counterparty.ID = strings.ToLower(strings.TrimSpace(counterparty.ID))
+	counterparty.LEI = strings.ToUpper(strings.TrimSpace(counterparty.LEI))
+	counterparty.Status = strings.ToLower(counterparty.Status)
+	if counterparty.Status == "" {
+		counterparty.Status = CounterpartyActive
+	}
+
+	if len(counterparty.ID) == 0 {
+		return counterparty, newError(ErrInvalidArgs, "Counterparty id must be a non-empty string")
+	}
+	if len(strings.TrimSpace(counterparty.LegalName)) == 0 {
+		return counterparty, newError(ErrInvalidArgs, "Counterparty legalname must be a non-empty string")
+	}
+	if !validLEI(counterparty.LEI) {
+		return counterparty, newError(ErrInvalidArgs, "Counterparty lei " + counterparty.LEI + " is not a valid LEI")
+	}
+	if counterparty.Status != CounterpartyActive && counterparty.Status != CounterpartySuspended {
+		return counterparty, newError(ErrInvalidArgs, "Counterparty status must be active or suspended")
+	}
+	return counterparty, nil
+}
+
+// ============================================================================================================================
+// validLEI - 20 alphanumeric characters whose ISO 7064 mod 97-10 check digits hold
+// ============================================================================================================================
+func validLEI(lei string) bool {
+	if len(lei) != 20 {
+		return false
+	}
+
+	var digits string
+	for _, c := range lei {
+		switch {
+		case c >= '0' && c <= '9':
+			digits += string(c)
+		case c >= 'A' && c <= 'Z':
+			digits += strconv.Itoa(int(c - 'A') + 10)					// A = 10 ... Z = 35
+		default:
+			return false
+		}
+	}
+
+	n, _ := new(big.Int).SetString(digits, 10)
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// ============================================================================================================================
+// putCounterparty - write a counterparty into the registry
+// ============================================================================================================================
+func putCounterparty(stub *shim.ChaincodeStub, counterparty Counterparty) error {
+	jsonAsBytes, _ := json.Marshal(counterparty)
+	return stub.PutState(counterpartyPrefix + counterparty.ID, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// add_counterparty - register a new counterparty
+// ============================================================================================================================
+func (t *SimpleChaincode) add_counterparty(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, a JSON counterparty")
+	}
+
+	fmt.Println("- start add_counterparty")
+
+	counterparty, err := parseCounterparty(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getCounterparty(stub, counterparty.ID)
+	if err == nil {
+		return nil, newError(ErrConflict, "Counterparty " + counterparty.ID + " already exists")
+	}
+
+	err = putCounterparty(stub, counterparty)
+	if err != nil {
+		return nil, err
+	}
+
+	counterpartyIndex, err := getIndex(stub, counterpartyIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	err = putIndex(stub, counterpartyIndexStr, append(counterpartyIndex, counterparty.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end add_counterparty")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// update_counterparty - replace the details of a registered counterparty
+// ============================================================================================================================
+func (t *SimpleChaincode) update_counterparty(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, a JSON counterparty")
+	}
+
+	fmt.Println("- start update_counterparty")
+
+	counterparty, err := parseCounterparty(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getCounterparty(stub, counterparty.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putCounterparty(stub, counterparty)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end update_counterparty")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// set_counterparty_status - activate or suspend a counterparty
+// ============================================================================================================================
+func (t *SimpleChaincode) set_counterparty_status(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//  0        1
+	// id, "suspended"
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, id and status")
+	}
+
+	fmt.Println("- start set_counterparty_status")
+
+	counterparty, err := getCounterparty(stub, strings.ToLower(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	status := strings.ToLower(args[1])
+	if status != CounterpartyActive && status != CounterpartySuspended {
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be active or suspended")
+	}
+	counterparty.Status = status
+
+	err = putCounterparty(stub, counterparty)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set_counterparty_status")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// get_counterparty - return one counterparty from the registry
+// ============================================================================================================================
+func (t *SimpleChaincode) get_counterparty(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting id of the counterparty")
+	}
+
+	counterparty, err := getCounterparty(stub, strings.ToLower(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(counterparty)
+}
+
+// ============================================================================================================================
+// list_counterparties - return every registered counterparty
+// ============================================================================================================================
+func (t *SimpleChaincode) list_counterparties(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	counterpartyIndex, err := getIndex(stub, counterpartyIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	counterparties := []Counterparty{}
+	for _, id := range counterpartyIndex {
+		counterparty, err := getCounterparty(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		counterparties = append(counterparties, counterparty)
+	}
+	return json.Marshal(counterparties)
+}
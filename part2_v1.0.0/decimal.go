@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact fixed-point number, unscaled / 10^scale. Floats are never used so every endorser computes the same result
+type Decimal struct {
+	unscaled *big.Int
+	scale int
+}
+
+// ============================================================================================================================
+// ParseDecimal - parse a plain decimal string such as "-12.345", rejecting more decimal places than scale allows
+// ============================================================================================================================
+func ParseDecimal(s string, scale int) (Decimal, error) {
+	str := strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		negative = str[0] == '-'
+		str = str[1:]
+	}
+
+	whole := str
+	fraction := ""
+	if i := strings.Index(str, "."); i >= 0 {
+		whole = str[:i]
+		fraction = str[i+1:]
+	}
+	if len(whole) == 0 || !isDigits(whole) || !isDigits(fraction) || (strings.Contains(str, ".") && len(fraction) == 0) {
+		return Decimal{}, newError(ErrInvalidArgs, "\"" + s + "\" is not a decimal number")
+	}
+	if len(fraction) > scale {
+		return Decimal{}, newError(ErrInvalidArgs, "\"" + s + "\" has more than " + strconv.Itoa(scale) + " decimal places")
+	}
+
+	unscaled, _ := new(big.Int).SetString(whole + fraction + strings.Repeat("0", scale - len(fraction)), 10)
+	if negative {
+		unscaled.Neg(unscaled)
+	}
+	return Decimal{unscaled, scale}, nil
+}
+
+// ============================================================================================================================
+// NewDecimal - a whole number as a decimal with the given scale
+// ============================================================================================================================
+func NewDecimal(n int64, scale int) Decimal {
+	unscaled := new(big.Int).Mul(big.NewInt(n), pow10(scale))
+	return Decimal{unscaled, scale}
+}
+
+// ============================================================================================================================
+// String - the number with exactly scale decimal places
+// ============================================================================================================================
+func (d Decimal) String() string {
+	if d.unscaled == nil {
+		return NewDecimal(0, d.scale).String()
+	}
+
+	digits := new(big.Int).Abs(d.unscaled).String()
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale - len(digits) + 1) + digits
+	}
+
+	str := digits
+	if d.scale > 0 {
+		str = digits[:len(digits) - d.scale] + "." + digits[len(digits) - d.scale:]
+	}
+	if d.unscaled.Sign() < 0 {
+		str = "-" + str
+	}
+	return str
+}
+
+// ============================================================================================================================
+// Rescale - the number at another scale, rounding half to even when decimal places are dropped
+// ============================================================================================================================
+func (d Decimal) Rescale(scale int) Decimal {
+	if scale >= d.scale {
+		return Decimal{new(big.Int).Mul(d.unscaled, pow10(scale - d.scale)), scale}
+	}
+
+	divisor := pow10(d.scale - scale)
+	quotient, remainder := new(big.Int).QuoRem(d.unscaled, divisor, new(big.Int))
+
+	// compare twice the remainder with the divisor to decide on rounding away from zero
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	cmp := twice.Cmp(divisor)
+	if cmp > 0 || (cmp == 0 && quotient.Bit(0) == 1) {
+		if d.unscaled.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return Decimal{quotient, scale}
+}
+
+// ============================================================================================================================
+// MulInt - the number times a whole number, at the same scale
+// ============================================================================================================================
+func (d Decimal) MulInt(n int64) Decimal {
+	return Decimal{new(big.Int).Mul(d.unscaled, big.NewInt(n)), d.scale}
+}
+
+// ============================================================================================================================
+// Mul - the exact product of two numbers, its scale is the sum of both scales
+// ============================================================================================================================
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{new(big.Int).Mul(d.unscaled, o.unscaled), d.scale + o.scale}
+}
+
+// ============================================================================================================================
+// IsMultipleOf - true if the number is a whole multiple of o, e.g. a price on a tick grid
+// ============================================================================================================================
+func (d Decimal) IsMultipleOf(o Decimal) bool {
+	scale := d.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	divisor := o.Rescale(scale).unscaled
+	if divisor.Sign() == 0 {
+		return false
+	}
+	return new(big.Int).Rem(d.Rescale(scale).unscaled, divisor).Sign() == 0
+}
+
+// ============================================================================================================================
+// Add - the sum of two numbers, at the larger of the two scales
+// ============================================================================================================================
+func (d Decimal) Add(o Decimal) Decimal {
+	scale := d.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	return Decimal{new(big.Int).Add(d.Rescale(scale).unscaled, o.Rescale(scale).unscaled), scale}
+}
+
+// ============================================================================================================================
+// Neg - the number with its sign flipped
+// ============================================================================================================================
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Int).Neg(d.unscaled), d.scale}
+}
+
+// ============================================================================================================================
+// Sign - -1, 0 or +1
+// ============================================================================================================================
+func (d Decimal) Sign() int {
+	return d.unscaled.Sign()
+}
+
+// ============================================================================================================================
+// Cmp - -1, 0 or +1 as d is less than, equal to or greater than o
+// ============================================================================================================================
+func (d Decimal) Cmp(o Decimal) int {
+	return d.Add(o.Neg()).Sign()
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Error codes, stable across releases so clients can branch on them instead of on the message
+const (
+	ErrInvalidArgs       = "INVALID_ARGS"			// wrong number of arguments or a value that does not validate
+	ErrNotFound          = "NOT_FOUND"				// nothing is stored under that key
+	ErrIllegalTransition = "ILLEGAL_TRANSITION"		// the record's status does not allow the requested step
+	ErrUnauthorized      = "UNAUTHORIZED"			// the caller lacks the role, or is not the user named in the arguments
+	ErrConflict          = "CONFLICT"				// the record already exists
+	ErrRejected          = "REJECTED"				// a business rule refused the request
+	ErrUnknownFunction   = "UNKNOWN_FUNCTION"
+	ErrInternal          = "INTERNAL"				// ledger access failed or stored data is unreadable
+)
+
+// ChaincodeError is the error every function returns, clients receive it serialised as JSON
+type ChaincodeError struct {
+	Code string `json:"code"`
+	Arg *int `json:"arg,omitempty"`					// index into args of the offending argument, counting from 0
+	Message string `json:"message"`
+}
+
+// ============================================================================================================================
+// Error - the human message, errors built from other errors read naturally, the code travels in the struct
+// ============================================================================================================================
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+// ============================================================================================================================
+// newError - an error with a code and a message
+// ============================================================================================================================
+func newError(code string, message string) error {
+	return &ChaincodeError{Code: code, Message: message}
+}
+
+// ============================================================================================================================
+// argError - an error about one argument, arg counts from 0 like args does
+// ============================================================================================================================
+func argError(code string, arg int, message string) error {
+	return &ChaincodeError{Code: code, Arg: &arg, Message: message}
+}
+
+// ============================================================================================================================
+// asChaincodeError - the error as a ChaincodeError, errors from the shim become INTERNAL
+// ============================================================================================================================
+func asChaincodeError(err error) *ChaincodeError {
+	chaincodeErr, ok := err.(*ChaincodeError)
+	if !ok {
+		chaincodeErr = &ChaincodeError{Code: ErrInternal, Message: err.Error()}
+	}
+	return chaincodeErr
+}
+
+// ============================================================================================================================
+// errorResponse - serialise an error as JSON for the client, errors from the shim become INTERNAL
+// ============================================================================================================================
+func errorResponse(err error) error {
+	if err == nil {
+		return nil
+	}
+	jsonAsBytes, _ := json.Marshal(asChaincodeError(err))
+	return errors.New(string(jsonAsBytes))
+}
+
+// ============================================================================================================================
+// errorCode - code of an error, INTERNAL for errors that are not a ChaincodeError
+// ============================================================================================================================
+func errorCode(err error) string {
+	if chaincodeErr, ok := err.(*ChaincodeError); ok {
+		return chaincodeErr.Code
+	}
+	return ErrInternal
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		err error
+		want string
+	}{
+		{newError(ErrNotFound, "No trade found for key trade_tx1_0"), `{"code":"NOT_FOUND","message":"No trade found for key trade_tx1_0"}`},
+		{argError(ErrInvalidArgs, 0, "1st argument must be delete or archive"), `{"code":"INVALID_ARGS","arg":0,"message":"1st argument must be delete or archive"}`},
+		{errors.New("ledger unavailable"), `{"code":"INTERNAL","message":"ledger unavailable"}`},
+	}
+	for _, test := range tests {
+		err := errorResponse(test.err)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("got %v, want %s", err, test.want)
+		}
+	}
+
+	if errorResponse(nil) != nil {
+		t.Error("no error became an error response")
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if code := errorCode(newError(ErrConflict, "exists")); code != ErrConflict {
+		t.Errorf("got %s, want %s", code, ErrConflict)
+	}
+	if code := errorCode(errors.New("ledger unavailable")); code != ErrInternal {
+		t.Errorf("got %s, want %s", code, ErrInternal)
+	}
+}
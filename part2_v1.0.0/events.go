@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// Chaincode event names, fabric keeps one event per transaction so every function sets at most one
+const (
+	EventTradeCreated        = "trade_created"
+	EventTradeRevisionNeeded = "trade_revision_needed"
+	EventTradeRevised        = "trade_revised"
+	EventTradeEnriched       = "trade_enriched"
+	EventTradeSettled        = "trade_settled"
+	EventTradeCancelled      = "trade_cancelled"
+	EventTradesSettled       = "trades_settled"				// net_settlements, one event for every trade it settled
+	EventTradesImported      = "trades_imported"			// import_trades, one event for every trade it created
+)
+
+// eventStub is the part of the stub events need, *shim.ChaincodeStub satisfies it
+type eventStub interface {
+	GetTxID() string
+	SetEvent(name string, payload []byte) error
+}
+
+// TradeEvent is the payload of every trade event
+type TradeEvent struct {
+	Key string `json:"key"`								// trade key
+	OldState string `json:"oldstate"`					// status before the change, empty for a new trade
+	NewState string `json:"newstate"`					// status after the change
+	Actor string `json:"actor"`							// user who made the change
+	TxID string `json:"txid"`
+}
+
+// BatchEvent is the payload of an event for a function that changes many trades in one transaction
+type BatchEvent struct {
+	Keys []string `json:"keys"`							// trade keys, in the order they were changed
+	NettingSets []string `json:"nettingsets,omitempty"`	// netting sets the trades settled in
+	OldState string `json:"oldstate"`					// status of every trade before the change, empty for new trades
+	NewState string `json:"newstate"`
+	Actor string `json:"actor"`
+	TxID string `json:"txid"`
+}
+
+// ============================================================================================================================
+// emitTradeEvent - set the chaincode event of the transaction, listeners receive it once the transaction commits
+// ============================================================================================================================
+func emitTradeEvent(stub eventStub, name string, key string, oldState string, newState string, actor string) error {
+	event := TradeEvent{}
+	event.Key = key
+	event.OldState = oldState
+	event.NewState = newState
+	event.Actor = actor
+	event.TxID = stub.GetTxID()
+
+	payload, _ := json.Marshal(event)
+	return stub.SetEvent(name, payload)
+}
+
+// ============================================================================================================================
+// emitBatchEvent - set one chaincode event for all the trades a function changed, fabric would only keep the last of many
+// ============================================================================================================================
+func emitBatchEvent(stub eventStub, name string, event BatchEvent) error {
+	event.TxID = stub.GetTxID()
+
+	payload, _ := json.Marshal(event)
+	return stub.SetEvent(name, payload)
+}
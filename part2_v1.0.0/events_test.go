@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+// eventRecorder keeps the event a function set, in place of a stub
+type eventRecorder struct {
+	txID string
+	name string
+	payload []byte
+}
+
+func (r *eventRecorder) GetTxID() string {
+	return r.txID
+}
+
+func (r *eventRecorder) SetEvent(name string, payload []byte) error {
+	r.name = name
+	r.payload = payload
+	return nil
+}
+
+func TestEmitTradeEvent(t *testing.T) {
+	recorder := &eventRecorder{txID: "tx1"}
+	err := emitTradeEvent(recorder, EventTradeEnriched, "trade_tx0_0", StatusSubmitted, StatusEnriched, "bo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.name != "trade_enriched" {
+		t.Errorf("event name is %s, want trade_enriched", recorder.name)
+	}
+	want := `{"key":"trade_tx0_0","oldstate":"submitted","newstate":"enriched","actor":"bo","txid":"tx1"}`
+	if string(recorder.payload) != want {
+		t.Errorf("payload is %s, want %s", recorder.payload, want)
+	}
+}
+
+func TestEmitBatchEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		event BatchEvent
+		want string
+	}{
+		{
+			EventTradesSettled,
+			BatchEvent{Keys: []string{"trade_a_0", "trade_b_0"}, NettingSets: []string{"_nettingset_tx1_0"}, OldState: StatusEnriched, NewState: StatusSettled, Actor: "ap"},
+			`{"keys":["trade_a_0","trade_b_0"],"nettingsets":["_nettingset_tx1_0"],"oldstate":"enriched","newstate":"settled","actor":"ap","txid":"tx1"}`,
+		},
+		{
+			EventTradesImported,
+			BatchEvent{Keys: []string{"trade_tx1_0", "trade_tx1_2"}, NewState: StatusSubmitted, Actor: "bob"},
+			`{"keys":["trade_tx1_0","trade_tx1_2"],"oldstate":"","newstate":"submitted","actor":"bob","txid":"tx1"}`,
+		},
+	}
+	for _, test := range tests {
+		recorder := &eventRecorder{txID: "tx1"}
+		err := emitBatchEvent(recorder, test.name, test.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorder.name != test.name {
+			t.Errorf("event name is %s, want %s", recorder.name, test.name)
+		}
+		if string(recorder.payload) != test.want {
+			t.Errorf("payload is %s, want %s", recorder.payload, test.want)
+		}
+	}
+}
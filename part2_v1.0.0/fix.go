@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var fixExecPrefix = "_fixexec_"					//prefix for the key/value that maps a FIX ExecID to the trade it created
+
+var fixSOH = "\x01"								//FIX field delimiter, logs and test tools often show it as "|"
+var fixPipeDelimiter = regexp.MustCompile(`\|([0-9]+=)`)	//a "|" standing in for SOH is followed by the next tag
+
+// FIXField is one tag=value pair of a FIX message, in message order
+type FIXField struct {
+	Tag int
+	Value string
+}
+
+// FIXMessage is a parsed FIX message, repeating groups are kept in order in Fields
+type FIXMessage struct {
+	Fields []FIXField
+}
+
+// ============================================================================================================================
+// get - value of the first occurrence of a tag, empty if it is missing
+// ============================================================================================================================
+func (m FIXMessage) get(tag int) string {
+	for _, field := range m.Fields {
+		if field.Tag == tag {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// ============================================================================================================================
+// parseFIX - split a raw FIX message into fields and check its BeginString, BodyLength (9) and CheckSum (10)
+// ============================================================================================================================
+func parseFIX(raw string) (FIXMessage, error) {
+	var message FIXMessage
+
+	if !strings.Contains(raw, fixSOH) {								// checksums are computed over SOH delimiters
+		raw = fixPipeDelimiter.ReplaceAllString(raw, fixSOH + "$1")		// a "|" inside a value is kept
+		if strings.HasSuffix(raw, "|") {
+			raw = strings.TrimSuffix(raw, "|") + fixSOH
+		}
+	}
+	if !strings.HasSuffix(raw, fixSOH) {
+		raw += fixSOH
+	}
+
+	for _, pair := range strings.Split(strings.TrimSuffix(raw, fixSOH), fixSOH) {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return message, newError(ErrInvalidArgs, "FIX field \"" + pair + "\" is not a tag=value pair")
+		}
+		tag, err := strconv.Atoi(pair[:i])
+		if err != nil || tag <= 0 {
+			return message, newError(ErrInvalidArgs, "FIX field \"" + pair + "\" does not start with a numeric tag")
+		}
+		message.Fields = append(message.Fields, FIXField{tag, pair[i+1:]})
+	}
+
+	if len(message.Fields) < 4 || message.Fields[0].Tag != 8 || message.Fields[1].Tag != 9 || message.Fields[len(message.Fields)-1].Tag != 10 {
+		return message, newError(ErrInvalidArgs, "FIX message must start with BeginString (8) and BodyLength (9) and end with CheckSum (10)")
+	}
+	if message.Fields[0].Value != "FIX.4.4" {
+		return message, newError(ErrInvalidArgs, "FIX BeginString " + message.Fields[0].Value + " is not supported, expecting FIX.4.4")
+	}
+
+	// body length counts from the field after BodyLength up to and including the delimiter before CheckSum
+	bodyStart := len("8=" + message.Fields[0].Value + fixSOH + "9=" + message.Fields[1].Value + fixSOH)
+	trailerStart := strings.LastIndex(raw, fixSOH + "10=") + 1
+	bodyLength, err := strconv.Atoi(message.Fields[1].Value)
+	if err != nil || bodyLength != trailerStart - bodyStart {
+		return message, newError(ErrInvalidArgs, "FIX BodyLength " + message.Fields[1].Value + " does not match the body length " + strconv.Itoa(trailerStart - bodyStart))
+	}
+
+	sum := 0
+	for i := 0; i < trailerStart; i++ {
+		sum += int(raw[i])
+	}
+	checksum := fmt.Sprintf("%03d", sum % 256)
+	if message.Fields[len(message.Fields)-1].Value != checksum {
+		return message, newError(ErrInvalidArgs, "FIX CheckSum " + message.Fields[len(message.Fields)-1].Value + " does not match the computed checksum " + checksum)
+	}
+	return message, nil
+}
+
+// ============================================================================================================================
+// fixCounterparty - contra firm of an execution, PartyID (448) with PartyRole (452) 17, or ContraBroker (375)
+// ============================================================================================================================
+func fixCounterparty(message FIXMessage) string {
+	partyID := ""
+	for _, field := range message.Fields {
+		switch field.Tag {
+		case 448:
+			partyID = field.Value
+		case 452:
+			if field.Value == "17" && partyID != "" {
+				return partyID
+			}
+			partyID = ""
+		}
+	}
+	return message.get(375)
+}
+
+// ============================================================================================================================
+// fixDate - ISO date from a FIX LocalMktDate, YYYYMMDD
+// ============================================================================================================================
+func fixDate(name string, value string) (string, error) {
+	if len(value) != 8 || !isDigits(value) {
+		return "", newError(ErrInvalidArgs, "FIX " + name + " " + value + " must be a date as YYYYMMDD")
+	}
+	return value[:4] + "-" + value[4:6] + "-" + value[6:], nil
+}
+
+// ============================================================================================================================
+// fixTrade - map an execution report onto a Trade, the trade is validated like any other when it is added
+// ============================================================================================================================
+func fixTrade(message FIXMessage) (Trade, error) {
+	trade := Trade{}
+
+	if message.get(35) != "8" {
+		return trade, newError(ErrInvalidArgs, "FIX MsgType (35) must be 8, an ExecutionReport")
+	}
+	if message.get(150) != "F" {
+		return trade, newError(ErrInvalidArgs, "FIX ExecType (150) must be F, only trade executions can be ingested")
+	}
+
+	var missing []string
+	for _, tag := range []int{17, 54, 31, 55, 75} {
+		if message.get(tag) == "" {
+			missing = append(missing, strconv.Itoa(tag))
+		}
+	}
+	if message.get(32) == "" && message.get(38) == "" {
+		missing = append(missing, "32")
+	}
+	if fixCounterparty(message) == "" {
+		missing = append(missing, "448")
+	}
+	if len(missing) > 0 {
+		return trade, newError(ErrInvalidArgs, "FIX ExecutionReport is missing required tags: " + strings.Join(missing, ", "))
+	}
+
+	switch message.get(54) {
+	case "1":
+		trade.Operation = "buy"
+	case "2":
+		trade.Operation = "sell"
+	default:
+		return trade, newError(ErrInvalidArgs, "FIX Side (54) " + message.get(54) + " is not supported, expecting 1 buy or 2 sell")
+	}
+
+	qty := message.get(32)											// LastQty, the executed quantity
+	if qty == "" {
+		qty = message.get(38)
+	}
+	quantity, err := ParseDecimal(qty, securityScale)
+	if err != nil || quantity.Rescale(0).Cmp(quantity) != 0 {
+		return trade, newError(ErrInvalidArgs, "FIX quantity " + qty + " must be a whole number")
+	}
+	trade.Quantity, err = strconv.Atoi(quantity.Rescale(0).String())
+	if err != nil {
+		return trade, newError(ErrInvalidArgs, "FIX quantity " + qty + " is too large")
+	}
+
+	trade.Price = message.get(31)									// LastPx, the execution price
+	trade.Security = strings.ToLower(message.get(55))
+	if idSource := message.get(22); (idSource == "1" || idSource == "4") && message.get(48) != "" {
+		trade.Security = strings.ToLower(message.get(48))			// CUSIP or ISIN in preference to the symbol
+	}
+	trade.Counterparty = strings.ToLower(fixCounterparty(message))
+
+	trade.TradeDate, err = fixDate("TradeDate (75)", message.get(75))
+	if err != nil {
+		return trade, err
+	}
+	trade.ValueDate = "auto"										// derived from the settlement cycle without SettlDate
+	if message.get(64) != "" {
+		trade.ValueDate, err = fixDate("SettlDate (64)", message.get(64))
+		if err != nil {
+			return trade, err
+		}
+	}
+	trade.Timestamp = message.get(60)								// TransactTime
+	return trade, nil
+}
+
+// ============================================================================================================================
+// ingestedTrade - key of the trade an ExecID was already ingested as, empty if it is new
+// ============================================================================================================================
+func ingestedTrade(stub stateReader, execID string) (string, error) {
+	execKey := fixExecPrefix + execID
+	existingAsBytes, err := stub.GetState(execKey)
+	if err != nil {
+		return "", newError(ErrInternal, "Failed to get value for key " + execKey)
+	}
+	return string(existingAsBytes), nil
+}
+
+// ============================================================================================================================
+// ingest_fix - create a trade from a FIX 4.4 ExecutionReport, an ExecID already ingested returns its trade again
+// ============================================================================================================================
+func (t *SimpleChaincode) ingest_fix(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//   0          1
+	// "user", "8=FIX.4.4|9=...|10=..."
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, user and a FIX message")
+	}
+
+	fmt.Println("- start ingest_fix")
+
+	user := strings.ToLower(args[0])
+	err := checkActor(stub, user)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := parseFIX(args[1])
+	if err != nil {
+		return nil, err
+	}
+	trade, err := fixTrade(message)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := ingestedTrade(stub, message.get(17))
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" {
+		fmt.Println("ExecID " + message.get(17) + " was already ingested as " + existing)
+		return []byte(existing), nil
+	}
+
+	trade.User = user
+	key, err := addTrade(stub, trade, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(fixExecPrefix + message.get(17), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitTradeEvent(stub, EventTradeCreated, key, "", StatusSubmitted, user)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end ingest_fix")
+	return []byte(key), nil											// send the new trade id back to the client
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================================================================
+// readFixture - a FIX message from testdata, "|" or SOH delimited
+// ============================================================================================================================
+func readFixture(t *testing.T, name string) string {
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestParseFIXRejects(t *testing.T) {
+	tests := []struct {
+		fixture string
+		message string											// start of the expected error
+	}{
+		{"bad_bodylength.fix", "FIX BodyLength 999 does not match"},
+		{"bad_checksum.fix", "FIX CheckSum 000 does not match"},
+	}
+	for _, test := range tests {
+		_, err := parseFIX(readFixture(t, test.fixture))
+		if errorCode(err) != ErrInvalidArgs || !strings.HasPrefix(err.Error(), test.message) {
+			t.Errorf("%s: got %v, want %s", test.fixture, err, test.message)
+		}
+	}
+}
+
+func TestFIXTrade(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want Trade
+	}{
+		{"execution_buy.fix", Trade{TradeDate: "2016-10-14", ValueDate: "2016-10-18", Operation: "buy", Quantity: 100,
+			Security: "ibm", Price: "152.25", Counterparty: "cp", Timestamp: "20161014-14:30:00"}},
+		{"execution_sell_isin.fix", Trade{TradeDate: "2016-10-14", ValueDate: "auto", Operation: "sell", Quantity: 250,
+			Security: "us4592001014", Price: "151.5", Counterparty: "cp", Timestamp: "20161014-15:00:00"}},
+		{"execution_text_pipe.fix", Trade{TradeDate: "2016-10-14", ValueDate: "auto", Operation: "buy", Quantity: 5,
+			Security: "ibm", Price: "150", Counterparty: "cp"}},
+	}
+	for _, test := range tests {
+		message, err := parseFIX(readFixture(t, test.fixture))
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		trade, err := fixTrade(message)
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		if !reflect.DeepEqual(trade, test.want) {
+			t.Errorf("%s: mapped to %+v, want %+v", test.fixture, trade, test.want)
+		}
+	}
+
+	message, err := parseFIX(readFixture(t, "execution_text_pipe.fix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := message.get(58); text != "allocated to A|B" {
+		t.Errorf("Text (58) parsed as %q, a \"|\" inside a value must be kept", text)
+	}
+}
+
+func TestFIXTradeRejects(t *testing.T) {
+	tests := []struct {
+		fixture string
+		message string
+	}{
+		{"order_status.fix", "FIX ExecType (150) must be F"},
+		{"missing_tags.fix", "FIX ExecutionReport is missing required tags: 31, 75"},
+	}
+	for _, test := range tests {
+		message, err := parseFIX(readFixture(t, test.fixture))
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		_, err = fixTrade(message)
+		if errorCode(err) != ErrInvalidArgs || !strings.HasPrefix(err.Error(), test.message) {
+			t.Errorf("%s: got %v, want %s", test.fixture, err, test.message)
+		}
+	}
+}
+
+// unreadableState is a ledger every read fails on
+type unreadableState struct{}
+
+func (unreadableState) GetState(key string) ([]byte, error) {
+	return nil, errors.New("ledger unavailable")
+}
+
+func TestIngestedTrade(t *testing.T) {
+	state := stateMap{fixExecPrefix + "EXEC-1001": []byte("trade_tx1_0")}
+
+	key, err := ingestedTrade(state, "EXEC-1001")
+	if err != nil || key != "trade_tx1_0" {
+		t.Errorf("ingested ExecID: got %q, %v, want trade_tx1_0", key, err)
+	}
+	key, err = ingestedTrade(state, "EXEC-1002")
+	if err != nil || key != "" {
+		t.Errorf("new ExecID: got %q, %v, want no trade", key, err)
+	}
+	_, err = ingestedTrade(unreadableState{}, "EXEC-1001")
+	if errorCode(err) != ErrInternal {
+		t.Errorf("failed read: got %v, want %s", err, ErrInternal)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Match states, stored in Trade.MatchStatus, trades booked by users without a party are not matched
+const (
+	MatchAlleged   = "alleged"				// only one side has booked the trade
+	MatchMatched   = "matched"				// both sides agree on every key economic field
+	MatchUnmatched = "unmatched"			// both sides have booked the trade but disagree, see Breaks
+)
+
+var matchBucketPrefix = "_matchbucket_"			//prefix for the key/value that lists the trades a party booked with a counterparty in a security
+
+// MatchReport is one line of the match report, Breaks lists the fields the two sides disagree on
+type MatchReport struct {
+	Key string `json:"key"`
+	Party string `json:"party"`
+	Counterparty string `json:"counterparty"`
+	Security string `json:"security"`
+	MatchStatus string `json:"matchstatus"`
+	MatchedWith string `json:"matchedwith"`
+	Breaks []string `json:"breaks"`
+}
+
+// ============================================================================================================================
+// tradeParty - party a user books trades for, empty if the user has none
+// ============================================================================================================================
+func tradeParty(stub *shim.ChaincodeStub, user string) (string, error) {
+	record, err := getUserRecord(stub, user)
+	if err != nil {
+		return "", err
+	}
+	return record.Party, nil
+}
+
+// ============================================================================================================================
+// matchBucketKey - key of the list of trades a party booked with a counterparty in a security
+// ============================================================================================================================
+func matchBucketKey(party string, counterparty string, security string) string {
+	return matchBucketPrefix + party + "/" + counterparty + "/" + security
+}
+
+// ============================================================================================================================
+// addToMatchBucket - list a trade under its party, counterparty and security so the other side's versions can find it
+// ============================================================================================================================
+func addToMatchBucket(stub *shim.ChaincodeStub, key string, trade Trade) error {
+	bucketKey := matchBucketKey(trade.Party, trade.Counterparty, trade.Security)
+	bucket, err := getIndex(stub, bucketKey)
+	if err != nil {
+		return err
+	}
+	for _, val := range bucket {
+		if val == key {
+			return nil
+		}
+	}
+	return putIndex(stub, bucketKey, append(bucket, key))
+}
+
+// ============================================================================================================================
+// matchTrade - look for the other side's version of a trade and update the match state of both sides
+//              only the counterparty's trades in the same security are read, see matchBucketKey
+//              the caller stores the trade, the other side's trade is stored here
+// ============================================================================================================================
+func matchTrade(stub *shim.ChaincodeStub, key string, trade *Trade) error {
+	if trade.Party == "" || trade.ReversalOf != "" {					// nothing to match against
+		return nil
+	}
+
+	err := releaseMatch(stub, key, trade)
+	if err != nil {
+		return err
+	}
+
+	err = addToMatchBucket(stub, key, *trade)
+	if err != nil {
+		return err
+	}
+
+	bucketKey := matchBucketKey(trade.Counterparty, trade.Party, trade.Security)	// the other side's versions
+	bucket, err := getIndex(stub, bucketKey)
+	if err != nil {
+		return err
+	}
+
+	// an exact match wins, otherwise report breaks against the closest version of the same security
+	bestKey := ""
+	var best Trade
+	var bestBreaks []string
+	var kept []string
+	for _, otherKey := range bucket {
+		other, err := getTrade(stub, otherKey)
+		if errorCode(err) == ErrNotFound {							// cleared or archived since it was listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		status := tradeStatus(other)
+		if other.Party != trade.Counterparty || other.Counterparty != trade.Party || other.Security != trade.Security || status == StatusCancelled || status == StatusFailed {
+			continue													// amended away or closed, drop it from the bucket
+		}
+		kept = append(kept, otherKey)
+		if otherKey == key || !matchCandidate(*trade, other) {
+			continue
+		}
+
+		breaks, err := tradeBreaks(stub, *trade, other)
+		if err != nil {
+			return err
+		}
+		if bestKey == "" || len(breaks) < len(bestBreaks) {
+			bestKey = otherKey
+			best = other
+			bestBreaks = breaks
+		}
+	}
+	if len(kept) != len(bucket) {
+		err = putIndex(stub, bucketKey, kept)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bestKey == "" {
+		trade.MatchStatus = MatchAlleged
+		trade.MatchedWith = ""
+		trade.Breaks = nil
+		return nil
+	}
+
+	err = releaseMatch(stub, bestKey, &best)
+	if err != nil {
+		return err
+	}
+
+	status := MatchMatched
+	if len(bestBreaks) > 0 {
+		status = MatchUnmatched
+	}
+	trade.MatchStatus = status
+	trade.MatchedWith = bestKey
+	trade.Breaks = bestBreaks
+	best.MatchStatus = status
+	best.MatchedWith = key
+	best.Breaks = bestBreaks
+	return putTrade(stub, bestKey, best)
+}
+
+// ============================================================================================================================
+// matchCandidate - true if other may be the counterparty's version of trade
+//                  it must be booked by the mirrored party on the same security and must not be matched already
+// ============================================================================================================================
+func matchCandidate(trade Trade, other Trade) bool {
+	if other.Party != trade.Counterparty || other.Counterparty != trade.Party {
+		return false
+	}
+	if other.Security != trade.Security || other.ReversalOf != "" || other.MatchStatus == MatchMatched {
+		return false
+	}
+	status := tradeStatus(other)
+	return status != StatusCancelled && status != StatusFailed
+}
+
+// ============================================================================================================================
+// tradeBreaks - key economic fields two versions of a trade disagree on, the operations must be opposite
+// ============================================================================================================================
+func tradeBreaks(stub *shim.ChaincodeStub, trade Trade, other Trade) ([]string, error) {
+	breaks := []string{}
+
+	if trade.TradeDate != other.TradeDate {
+		breaks = append(breaks, "tradedate")
+	}
+	if trade.ValueDate != other.ValueDate {
+		breaks = append(breaks, "valuedate")
+	}
+	opposite, err := oppositeOperation(trade.Operation)
+	if err != nil || other.Operation != opposite {
+		breaks = append(breaks, "operation")
+	}
+	if trade.Quantity != other.Quantity {
+		breaks = append(breaks, "quantity")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	price, err1 := ParseDecimal(trade.Price, config.PriceScale)
+	otherPrice, err2 := ParseDecimal(other.Price, config.PriceScale)
+	if err1 != nil || err2 != nil {
+		if trade.Price != other.Price {
+			breaks = append(breaks, "price")
+		}
+	} else if price.Cmp(otherPrice) != 0 {
+		breaks = append(breaks, "price")
+	}
+	return breaks, nil
+}
+
+// ============================================================================================================================
+// releaseMatch - drop the pairing between a trade and the trade it was matched with, the other side becomes alleged again
+// ============================================================================================================================
+func releaseMatch(stub *shim.ChaincodeStub, key string, trade *Trade) error {
+	if trade.MatchedWith == "" {
+		return nil
+	}
+
+	otherKey := trade.MatchedWith
+	trade.MatchStatus = MatchAlleged
+	trade.MatchedWith = ""
+	trade.Breaks = nil
+
+	other, err := getTrade(stub, otherKey)
+	if err != nil || other.MatchedWith != key {						// the other side has moved on already
+		return nil
+	}
+	other.MatchStatus = MatchAlleged
+	other.MatchedWith = ""
+	other.Breaks = nil
+	return putTrade(stub, otherKey, other)
+}
+
+// ============================================================================================================================
+// checkMatched - trades taking part in matching may only settle once both sides agree
+// ============================================================================================================================
+func checkMatched(key string, trade Trade) error {
+	if trade.MatchStatus == "" || trade.MatchStatus == MatchMatched {
+		return nil
+	}
+	if trade.MatchStatus == MatchUnmatched {
+		return newError(ErrRejected, "Trade " + key + " is unmatched with " + trade.MatchedWith + ", breaks: " + strings.Join(trade.Breaks, ", "))
+	}
+	return newError(ErrRejected, "Trade " + key + " is " + trade.MatchStatus + ", the counterparty has not booked it yet")
+}
+
+// ============================================================================================================================
+// set_user_party - set the party, a registered counterparty id, a user books trades for
+// ============================================================================================================================
+func (t *SimpleChaincode) set_user_party(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "bob", "party"
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, user and party")
+	}
+
+	fmt.Println("- start set_user_party")
+
+	id := strings.ToLower(args[0])
+	party := strings.ToLower(args[1])
+
+	user, err := getUserRecord(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if party != "" {
+		_, err = getCounterparty(stub, party)
+		if err != nil {
+			return nil, err
+		}
+	}
+	user.Party = party
+
+	jsonAsBytes, _ := json.Marshal(user)
+	err = stub.PutState(userPrefix + id, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end set_user_party")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// match_trade - run matching again for a trade, e.g. after the other side corrected its version
+// ============================================================================================================================
+func (t *SimpleChaincode) match_trade(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting key of the trade")
+	}
+
+	fmt.Println("- start match_trade")
+
+	key := strings.ToLower(args[0])
+	trade, err := getTrade(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	if trade.Party == "" {
+		return nil, newError(ErrRejected, "Trade " + key + " was booked without a party and cannot be matched")
+	}
+	if trade.MatchStatus == MatchMatched {
+		return []byte(trade.MatchStatus), nil
+	}
+
+	err = matchTrade(stub, key, &trade)
+	if err != nil {
+		return nil, err
+	}
+	err = putTrade(stub, key, trade)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end match_trade")
+	return []byte(trade.MatchStatus), nil
+}
+
+// ============================================================================================================================
+// match_report - match state and breaks of every active trade taking part in matching, optionally of one match state
+// ============================================================================================================================
+func (t *SimpleChaincode) match_report(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an optional match status")
+	}
+
+	status := ""
+	if len(args) == 1 {
+		status = strings.ToLower(args[0])
+		if status != MatchAlleged && status != MatchMatched && status != MatchUnmatched {
+			return nil, argError(ErrInvalidArgs, 0, "Match status must be alleged, matched or unmatched")
+		}
+	}
+
+	fmt.Println("- start match_report")
+
+	tradeIndex, err := getTradeIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	report := []MatchReport{}
+	for _, key := range tradeIndex {
+		trade, err := getTrade(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if trade.MatchStatus == "" || (status != "" && trade.MatchStatus != status) {
+			continue
+		}
+
+		line := MatchReport{}
+		line.Key = key
+		line.Party = trade.Party
+		line.Counterparty = trade.Counterparty
+		line.Security = trade.Security
+		line.MatchStatus = trade.MatchStatus
+		line.MatchedWith = trade.MatchedWith
+		line.Breaks = trade.Breaks
+		if line.Breaks == nil {
+			line.Breaks = []string{}
+		}
+		report = append(report, line)
+	}
+
+	fmt.Println("- end match_report")
+	return json.Marshal(report)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var nettingSetPrefix = "_nettingset_"				//prefix for the key/value that stores a netting set
+var nettingSetIndexStr = "_nettingsetindex"			//name for the key/value that will store a list of all netting set keys
+
+// NettingSet is a group of trades with one counterparty, security and value date settled together as one net movement
+type NettingSet struct {
+	ID string `json:"id"`
+	Counterparty string `json:"counterparty"`
+	Security string `json:"security"`
+	ValueDate string `json:"valuedate"`
+	NetQuantity int `json:"netquantity"`				// buys add and sells subtract, negative means a net delivery
+	NetCash string `json:"netcash"`					// sells add and buys subtract, negative means a net payment
+	TradeKeys []string `json:"tradekeys"`
+	SettledBy string `json:"settledby"`				// approver who settled the set
+	TxTime string `json:"txtime"`
+}
+
+// ============================================================================================================================
+// nettingGroup - key trades are grouped by, one netting set per counterparty, security and value date
+// ============================================================================================================================
+func nettingGroup(trade Trade) string {
+	return trade.Counterparty + "/" + trade.Security + "/" + trade.ValueDate
+}
+
+// ============================================================================================================================
+// getNettingSet - read a netting set, error if it does not exist
+// ============================================================================================================================
+func getNettingSet(stub stateReader, key string) (NettingSet, error) {
+	var set NettingSet
+
+	setAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return set, newError(ErrInternal, "Failed to get value for key " + key)
+	}
+	if len(setAsBytes) == 0 {
+		return set, newError(ErrNotFound, "No netting set found for key " + key)
+	}
+
+	json.Unmarshal(setAsBytes, &set)								// un stringify it aka JSON.parse()
+	return set, nil
+}
+
+// ============================================================================================================================
+// net_settlements - settle enriched trades as netting sets, one per counterparty, security and value date
+//                   only trades the caller may approve are netted, see checkFourEyes and checkMatched
+// ============================================================================================================================
+func (t *SimpleChaincode) net_settlements(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//   0        1         2
+	// "user", filter, "reason"
+	if len(args) < 1 || len(args) > 3 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1 to 3, user, plus an optional JSON filter and reason")
+	}
+
+	fmt.Println("- start net_settlements")
+
+	user := strings.ToLower(args[0])
+	err := checkActor(stub, user)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := TradeFilter{}
+	if len(args) > 1 {
+		filter, err = parseTradeFilter(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filter.Status != "" && filter.Status != StatusEnriched {
+		return nil, argError(ErrInvalidArgs, 1, "Only enriched trades can be netted, leave the filter status empty")
+	}
+
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	tradeIndex, err := getTradeIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// group the eligible trades, keeping groups in index order so every peer builds the same sets
+	var groups []string
+	members := map[string][]string{}
+	for _, key := range tradeIndex {
+		trade, err := getTrade(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.matches(trade) || checkFourEyes(key, trade, user) != nil || checkMatched(key, trade) != nil {
+			continue
+		}
+
+		group := nettingGroup(trade)
+		if _, ok := members[group]; !ok {
+			groups = append(groups, group)
+		}
+		members[group] = append(members[group], key)
+	}
+
+	setIndex, err := getIndex(stub, nettingSetIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	setKeys := []string{}
+	settled := BatchEvent{OldState: StatusEnriched, NewState: StatusSettled, Actor: user}
+	for seq, group := range groups {
+		keys := members[group]
+		first, err := getTrade(stub, keys[0])
+		if err != nil {
+			return nil, err
+		}
+
+		set := NettingSet{}
+		set.ID = nettingSetPrefix + strings.ToLower(stub.GetTxID()) + "_" + strconv.Itoa(seq)
+		set.Counterparty = first.Counterparty
+		set.Security = first.Security
+		set.ValueDate = first.ValueDate
+		set.SettledBy = user
+		set.TxTime = txTime
+
+		netCash := NewDecimal(0, config.AmountScale)
+		for _, key := range keys {
+			trade, err := getTrade(stub, key)
+			if err != nil {
+				return nil, err
+			}
+			err = backfillTrade(stub, key, &trade)
+			if err != nil {
+				return nil, err
+			}
+			consideration, err := ParseDecimal(trade.Consideration, config.AmountScale)
+			if err != nil {
+				return nil, newError(ErrInternal, "Consideration of trade " + key + " " + err.Error())
+			}
+			if trade.Operation == "buy" {
+				set.NetQuantity += trade.Quantity
+				netCash = netCash.Add(consideration.Neg())
+			} else {
+				set.NetQuantity -= trade.Quantity
+				netCash = netCash.Add(consideration)
+			}
+
+			trade.ApprovedBy = user
+			trade.NettingSet = set.ID
+			err = moveTrade(stub, key, &trade, StatusSettled, user, reason)	// books each trade into its position
+			if err != nil {
+				return nil, err
+			}
+			trade.User = user
+
+			err = putTrade(stub, key, trade)
+			if err != nil {
+				return nil, err
+			}
+			set.TradeKeys = append(set.TradeKeys, key)
+			settled.Keys = append(settled.Keys, key)
+		}
+		set.NetCash = netCash.String()
+
+		jsonAsBytes, _ := json.Marshal(set)
+		err = stub.PutState(set.ID, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		setIndex = append(setIndex, set.ID)
+		setKeys = append(setKeys, set.ID)
+	}
+
+	err = putIndex(stub, nettingSetIndexStr, setIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(setKeys) > 0 {
+		settled.NettingSets = setKeys
+		err = emitBatchEvent(stub, EventTradesSettled, settled)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("- end net_settlements")
+	return json.Marshal(setKeys)										// keys of the netting sets settled
+}
+
+// ============================================================================================================================
+// get_netting_set - return one netting set
+// ============================================================================================================================
+func (t *SimpleChaincode) get_netting_set(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting key of the netting set")
+	}
+
+	set, err := getNettingSet(stub, strings.ToLower(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(set)
+}
+
+// ============================================================================================================================
+// list_netting_sets - return every netting set
+// ============================================================================================================================
+func (t *SimpleChaincode) list_netting_sets(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	setIndex, err := getIndex(stub, nettingSetIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := []NettingSet{}
+	for _, key := range setIndex {
+		set, err := getNettingSet(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return json.Marshal(sets)
+}
@@ -20,7 +20,6 @@ under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"encoding/json"
@@ -37,17 +36,33 @@ type SimpleChaincode struct {
 var tradeIndexStr = "_tradeindex"				//name for the key/value that will store a list of all known trades
 
 type Trade struct {
-	TradeDate string `json:"tradedate"`
-	ValueDate string `json:"valuedate"`
+	TradeDate string `json:"tradedate"`				// ISO date
+	ValueDate string `json:"valuedate"`				// ISO date, "auto" or "t+N" on creation derives it in business days
 	Operation string `json:"operation"`
 	Quantity int `json:"quantity,string"`
 	Security string `json:"security"`
-	Price string `json:"price"`
+	Price string `json:"price"`						// fixed-point decimal at the configured price scale
 	Counterparty string `json:"counterparty"`
-	User string `json:"user"`
-	Timestamp string `json:"timestamp"`			// utc timestamp of creation, use JS/jQuery timestamp as string
+	User string `json:"user"`						// user the trade is currently with
+	Trader string `json:"trader"`					// user who booked the trade, positions are kept per trader
+	ID string `json:"id"`							// ledger key, derived from the creating transaction
+	Timestamp string `json:"timestamp"`			// client timestamp of creation, JS/jQuery timestamp as string
 	Settled int `json:"settled,string"`			// enriched & settled
 	NeedsRevision int `json:"needsrevision,string"`	// returned to client for revision
+	Status string `json:"status"`					// lifecycle state, see trade_status.go
+	Version int `json:"version"`					// bumped by every amendment, prior versions kept on ledger
+	ReversalOf string `json:"reversalof,omitempty"`	// key of the settled trade this trade reverses
+	ReversedBy string `json:"reversedby,omitempty"`	// key of the trade that reversed this one
+	Enrichment *Enrichment `json:"enrichment,omitempty"`	// settlement details, see trade_enrich.go
+	EnrichedBy string `json:"enrichedby,omitempty"`	// user who added the settlement details
+	ApprovedBy string `json:"approvedby,omitempty"`	// user who approved settlement, never the enricher
+	Party string `json:"party,omitempty"`			// counterparty id of the firm that booked this side of the trade
+	MatchStatus string `json:"matchstatus,omitempty"`	// alleged, matched or unmatched, see matching.go
+	MatchedWith string `json:"matchedwith,omitempty"`	// key of the other side's version of the trade
+	Breaks []string `json:"breaks,omitempty"`		// fields the two sides disagree on
+	NettingSet string `json:"nettingset,omitempty"`	// key of the netting set the trade settled in, see netting.go
+	Consideration string `json:"consideration"`	// gross consideration, quantity x price, see decimal.go
+	Schema int `json:"schema"`					// storage layout version, see trade_schema.go
 }
 
 // ============================================================================================================================
@@ -67,14 +82,16 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 	var Aval int
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	//  0          1
+	// "99", "admin user"
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, plus an optional admin user")
 	}
 
 	// Initialize the chaincode
 	Aval, err = strconv.Atoi(args[0])
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be an integer value for asset holding")
 	}
 
 	// Write the state to the ledger
@@ -89,6 +106,13 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 	if err != nil {
 		return nil, err
 	}
+
+	if len(args) > 1 {
+		err = putUserRecord(stub, args[1], []string{RoleAdmin})			// bootstrap the first admin
+		if err != nil {
+			return nil, err
+		}
+	}
 	
 	return nil, nil
 
@@ -97,10 +121,16 @@ func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte,
 // ============================================================================================================================
 // Run - Our entry point for Invokcations
 // ============================================================================================================================
-func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
 	
 	fmt.Println("run is running " + function)
 
+	err = authorize(stub, function)										// check the caller's roles
+	if err != nil {
+		return nil, err
+	}
+
 	// Handle different functions
 	if function == "init" {													// initialize the chaincode state, used as reset
 		return t.init(stub, args)
@@ -108,29 +138,73 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 		return t.write(stub, args)
 	} else if function == "create_and_submit_trade" {								// create and submit a new trade
 		return t.create_and_submit_trade(stub, args)
+	} else if function == "ingest_fix" {										// create a trade from a FIX execution report
+		return t.ingest_fix(stub, args)
+	} else if function == "import_trades" {									// create trades from a CSV payload
+		return t.import_trades(stub, args)
 	} else if function == "mark_revision_needed" {								
 		return t.mark_revision_needed(stub, args)
 	} else if function == "mark_revised" {								
 		return t.mark_revised(stub, args)
-	} else if function == "enrich_and_settle" {								
-		return t.enrich_and_settle(stub, args)
+	} else if function == "enrich_trade" {									// add settlement details, first pair of eyes
+		return t.enrich_trade(stub, args)
+	} else if function == "approve_settlement" {							// settle an enriched trade, second pair of eyes
+		return t.approve_settlement(stub, args)
+	} else if function == "net_settlements" {								// settle enriched trades as netting sets
+		return t.net_settlements(stub, args)
+	} else if function == "set_config" {									// change chaincode settings
+		return t.set_config(stub, args)
+	} else if function == "add_holiday" {									// maintain the holiday calendar
+		return t.add_holiday(stub, args)
+	} else if function == "remove_holiday" {
+		return t.remove_holiday(stub, args)
+	} else if function == "add_counterparty" {								// maintain the counterparty registry
+		return t.add_counterparty(stub, args)
+	} else if function == "update_counterparty" {
+		return t.update_counterparty(stub, args)
+	} else if function == "set_counterparty_status" {
+		return t.set_counterparty_status(stub, args)
+	} else if function == "add_security" {									// maintain the security master
+		return t.add_security(stub, args)
+	} else if function == "update_security" {
+		return t.update_security(stub, args)
+	} else if function == "register_user" {								// maintain the user registry
+		return t.register_user(stub, args)
+	} else if function == "remove_user" {
+		return t.remove_user(stub, args)
+	} else if function == "set_user_party" {
+		return t.set_user_party(stub, args)
+	} else if function == "cancel_trade" {									// cancel a single trade
+		return t.cancel_trade(stub, args)
+	} else if function == "match_trade" {									// match a trade against the other side's version
+		return t.match_trade(stub, args)
 	} else if function == "clear_all_trades" {								
 		return t.clear_all_trades(stub, args)
+	} else if function == "archive_trades" {								// move old settled and cancelled trades to the archive
+		return t.archive_trades(stub, args)
+	} else if function == "migrate_records" {								// rewrite old trade records to the current schema
+		return t.migrate_records(stub, args)
 	}
 
 	fmt.Println("run did not find func: " + function)						// error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 
 }
 
 // ============================================================================================================================
 // Invoke - Our entry point for Invokcations
 // ============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
 	
 	fmt.Println("invoke is running " + function)
 
+	err = authorize(stub, function)										// check the caller's roles
+	if err != nil {
+		return nil, err
+	}
+
 	// Handle different functions
 	if function == "init" {													// initialize the chaincode state, used as reset
 		return t.init(stub, args)
@@ -138,26 +212,65 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 		return t.write(stub, args)
 	} else if function == "create_and_submit_trade" {								// create and submit a new trade
 		return t.create_and_submit_trade(stub, args)
+	} else if function == "ingest_fix" {										// create a trade from a FIX execution report
+		return t.ingest_fix(stub, args)
+	} else if function == "import_trades" {									// create trades from a CSV payload
+		return t.import_trades(stub, args)
 	} else if function == "mark_revision_needed" {								
 		return t.mark_revision_needed(stub, args)
 	} else if function == "mark_revised" {								
 		return t.mark_revised(stub, args)
-	} else if function == "enrich_and_settle" {								
-		return t.enrich_and_settle(stub, args)
+	} else if function == "enrich_trade" {									// add settlement details, first pair of eyes
+		return t.enrich_trade(stub, args)
+	} else if function == "approve_settlement" {							// settle an enriched trade, second pair of eyes
+		return t.approve_settlement(stub, args)
+	} else if function == "net_settlements" {								// settle enriched trades as netting sets
+		return t.net_settlements(stub, args)
+	} else if function == "set_config" {									// change chaincode settings
+		return t.set_config(stub, args)
+	} else if function == "add_holiday" {									// maintain the holiday calendar
+		return t.add_holiday(stub, args)
+	} else if function == "remove_holiday" {
+		return t.remove_holiday(stub, args)
+	} else if function == "add_counterparty" {								// maintain the counterparty registry
+		return t.add_counterparty(stub, args)
+	} else if function == "update_counterparty" {
+		return t.update_counterparty(stub, args)
+	} else if function == "set_counterparty_status" {
+		return t.set_counterparty_status(stub, args)
+	} else if function == "add_security" {									// maintain the security master
+		return t.add_security(stub, args)
+	} else if function == "update_security" {
+		return t.update_security(stub, args)
+	} else if function == "register_user" {								// maintain the user registry
+		return t.register_user(stub, args)
+	} else if function == "remove_user" {
+		return t.remove_user(stub, args)
+	} else if function == "set_user_party" {
+		return t.set_user_party(stub, args)
+	} else if function == "cancel_trade" {									// cancel a single trade
+		return t.cancel_trade(stub, args)
+	} else if function == "match_trade" {									// match a trade against the other side's version
+		return t.match_trade(stub, args)
 	} else if function == "clear_all_trades" {								
 		return t.clear_all_trades(stub, args)
+	} else if function == "archive_trades" {								// move old settled and cancelled trades to the archive
+		return t.archive_trades(stub, args)
+	} else if function == "migrate_records" {								// rewrite old trade records to the current schema
+		return t.migrate_records(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function)						// error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 
 }
 
 // ============================================================================================================================
 // Init - Our entry point for Invokcations
 // ============================================================================================================================
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
 	
 	fmt.Println("run is running " + function)
 
@@ -168,25 +281,58 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 
 	fmt.Println("run did not find func: " + function)						// error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(ErrUnknownFunction, "Received unknown function invocation")
 
 }
 
 // ============================================================================================================================
 // Query - Our entry point for Queries
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) (payload []byte, err error) {
+	defer func() { err = errorResponse(err) }()						// clients get every error as JSON, see errors.go
 
 	fmt.Println("query is running " + function)
 
 	// Handle different functions
 	if function == "read" {													//read a variable
 		return t.read(stub, args)
+	} else if function == "trade_history" {									//audit trail of a trade
+		return t.trade_history(stub, args)
+	} else if function == "list_trades" {									//filtered list of trades
+		return t.list_trades(stub, args)
+	} else if function == "list_trades_page" {								//one page of trades
+		return t.list_trades_page(stub, args)
+	} else if function == "trade_version" {								//trade as of a version
+		return t.trade_version(stub, args)
+	} else if function == "read_archived" {								//archived trade with its history
+		return t.read_archived(stub, args)
+	} else if function == "get_position" {									//a user's holding in a security
+		return t.get_position(stub, args)
+	} else if function == "list_positions" {								//all holdings, optionally for one user
+		return t.list_positions(stub, args)
+	} else if function == "get_counterparty" {								//one registered counterparty
+		return t.get_counterparty(stub, args)
+	} else if function == "list_counterparties" {							//all registered counterparties
+		return t.list_counterparties(stub, args)
+	} else if function == "get_security" {									//one instrument from the security master
+		return t.get_security(stub, args)
+	} else if function == "list_securities" {								//all instruments in the security master
+		return t.list_securities(stub, args)
+	} else if function == "list_users" {									//all registered users and their roles
+		return t.list_users(stub, args)
+	} else if function == "match_report" {									//match state and breaks of trades
+		return t.match_report(stub, args)
+	} else if function == "get_netting_set" {								//one netting set
+		return t.get_netting_set(stub, args)
+	} else if function == "list_netting_sets" {							//all netting sets
+		return t.list_netting_sets(stub, args)
+	} else if function == "settlement_instruction" {						//MT541/MT543 or sese.023 for a trade or netting set
+		return t.settlement_instruction(stub, args)
 	}
 
 	fmt.Println("query did not find func: " + function)						//error
 
-	return nil, errors.New("Received unknown function query")
+	return nil, newError(ErrUnknownFunction, "Received unknown function query")
 
 }
 
@@ -195,18 +341,17 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 // ============================================================================================================================
 func (t *SimpleChaincode) read(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 	
-	var key, jsonResp string
+	var key string
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting key of the value to query")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting key of the value to query")
 	}
 
 	key = args[0]
 	valAsbytes, err := stub.GetState(key)									//get the var from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(ErrInternal, "Failed to get state for " + key)
 	}
 
 	return valAsbytes, nil													//send it onward
@@ -224,7 +369,7 @@ func (t *SimpleChaincode) write(stub *shim.ChaincodeStub, args []string) ([]byte
 	fmt.Println("running write()")
 
 	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. timestamp of the variable and value to set")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, name of the variable and value to set")
 	}
 
 	timestamp = args[0]													
@@ -245,7 +390,7 @@ func (t *SimpleChaincode) create_and_submit_trade(stub *shim.ChaincodeStub, args
 	var err error
 
 	if len(args) != 11 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 11")
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 11")
 	}
 
 	fmt.Println("- start create_and_submit_trade")
@@ -269,60 +414,164 @@ func (t *SimpleChaincode) create_and_submit_trade(stub *shim.ChaincodeStub, args
 
 	quantity, err := strconv.Atoi(args[3])
 	if err != nil {
-		return nil, errors.New("4th argument must be a numeric string")
+		return nil, argError(ErrInvalidArgs, 3, "4th argument must be a numeric string")
 	}
 
 	security := strings.ToLower(args[4])
 	price := strings.ToLower(args[5])
 	counterparty := strings.ToLower(args[6])
 	user := strings.ToLower(args[7])
+	err = checkActor(stub, user)
+	if err != nil {
+		return nil, err
+	}
 	
-	// use jquery timestamp string for now with time zone
+	// jquery timestamp string from the client, kept for display only
 	timestamp := strings.ToLower(args[8])
 
-	// timestamp := makeTimestamp()
-	// timestampAsString := strconv.FormatInt(timestamp, 10)
-
 	settled, err := strconv.Atoi(args[9])
 	if err != nil {
-		return nil, errors.New("9th argument must be a numeric string, either 0 or 1")
+		return nil, argError(ErrInvalidArgs, 9, "10th argument must be a numeric string, either 0 or 1")
 	}
 
 	needsrevision, err := strconv.Atoi(args[10])
 	if err != nil {
-		return nil, errors.New("10th argument must be a numeric string, either 0 or 1")
+		return nil, argError(ErrInvalidArgs, 10, "11th argument must be a numeric string, either 0 or 1")
 	}
 
-	str := `{"tradedate": "` + tradedate + `", "valuedate": "` + valuedate + `", "operation": "` + operation + `", "quantity": ` + strconv.Itoa(quantity) + `, "security": "` + security + `", "price": "` + price + `", "counterparty": "` + counterparty + `", "user": "` + user + `", "timestamp": "` + timestamp + `", "settled": "` + strconv.Itoa(settled) + `", "needsrevision": "` + strconv.Itoa(needsrevision) + `"}`
-
-	fmt.Println("str: ", str)
+	if settled != 0 || needsrevision != 0 {
+		return nil, newError(ErrInvalidArgs, "A new trade must be submitted with settled and needsrevision set to 0")
+	}
 
-	err = stub.PutState(timestamp, []byte(str))							// store trade with timestamp as key
+	trade := Trade{}
+	trade.TradeDate = tradedate
+	trade.ValueDate = valuedate
+	trade.Operation = operation
+	trade.Quantity = quantity
+	trade.Security = security
+	trade.Price = price
+	trade.Counterparty = counterparty
+	trade.User = user
+	trade.Timestamp = timestamp
+
+	key, err := addTrade(stub, trade, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("put state for timestamp key: ", timestamp)
-		
-	tradesAsBytes, err := stub.GetState(tradeIndexStr)					//get the trade index
+	err = emitTradeEvent(stub, EventTradeCreated, key, "", StatusSubmitted, user)
 	if err != nil {
-		return nil, errors.New("Failed to get value for key _tradeIndex")
+		return nil, err
 	}
 
-	var tradeIndex []string
-	json.Unmarshal(tradesAsBytes, &tradeIndex)							// un stringify it aka JSON.parse()
+	fmt.Println("- end create_and_submit_trade")
+	return []byte(key), nil											// send the new trade id back to the client
 
-	fmt.Println("stored timestamp at end of tradeIndexStr array: ", tradeIndex)
+}
 
-	//append
-	tradeIndex = append(tradeIndex, timestamp)					// add trade timestamp to index list
+// ============================================================================================================================
+// addTrade - store a new submitted trade under an id derived from the transaction and add it to the trade index
+//            seq numbers the trades created by one transaction, starting at 0
+// ============================================================================================================================
+func addTrade(stub *shim.ChaincodeStub, trade Trade, seq int) (string, error) {
+
+	key, err := storeTrade(stub, trade, seq)
+	if err != nil {
+		return "", err
+	}
+
+	tradeIndex, err := getTradeIndex(stub)
+	if err != nil {
+		return "", err
+	}
+
+	tradeIndex = append(tradeIndex, key)								// add trade id to index list
 	fmt.Println("! trade index: ", tradeIndex)
 	jsonAsBytes, _ := json.Marshal(tradeIndex)
 	err = stub.PutState(tradeIndexStr, jsonAsBytes)						// store name of trade
+	if err != nil {
+		return "", err
+	}
 
-	fmt.Println("- end create_and_submit_trade")
-	return nil, nil
+	return key, nil
+}
+
+// ============================================================================================================================
+// storeTrade - validate and store a new submitted trade without indexing it, callers adding many trades index them once
+// ============================================================================================================================
+func storeTrade(stub *shim.ChaincodeStub, trade Trade, seq int) (string, error) {
+
+	key := newTradeID(stub, seq)
+
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return "", newError(ErrInternal, "Failed to get value for key " + key)
+	}
+	if len(existingAsBytes) > 0 {
+		return "", newError(ErrConflict, "A trade already exists for key " + key)
+	}
+
+	err = resolveValueDate(stub, &trade, 1)							// the value date, FIX message or CSV payload is the 2nd argument
+	if err != nil {
+		return "", err
+	}
+
+	err = validateTrade(trade)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkReferenceData(stub, trade)
+	if err != nil {
+		return "", err
+	}
+
+	err = priceTrade(stub, &trade)
+	if err != nil {
+		return "", err
+	}
+
+	if trade.Trader == "" {
+		trade.Trader = trade.User
+	}
+	trade.ID = key
+	trade.Status = StatusSubmitted
+	trade.Version = 1
+
+	if trade.Party == "" {
+		trade.Party, err = tradeParty(stub, trade.User)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = matchTrade(stub, key, &trade)								// pair with the counterparty's version, if booked
+	if err != nil {
+		return "", err
+	}
+
+	err = putTrade(stub, key, trade)									// store trade with its id as key
+	if err != nil {
+		return "", err
+	}
+
+	entry := AuditEntry{}
+	entry.To = StatusSubmitted
+	entry.Version = trade.Version
+	entry.User = trade.User
+	err = appendHistory(stub, key, entry)								// first entry of the audit trail
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("put state for trade key: ", key)
+	return key, nil
+}
 
+// ============================================================================================================================
+// newTradeID - unique trade id from the transaction id, the same on every peer
+// ============================================================================================================================
+func newTradeID(stub *shim.ChaincodeStub, seq int) string {
+	return "trade_" + strings.ToLower(stub.GetTxID()) + "_" + strconv.Itoa(seq)
 }
 
 
@@ -332,36 +581,50 @@ func (t *SimpleChaincode) mark_revision_needed(stub *shim.ChaincodeStub, args []
 	
 	var err error
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 11")
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, key and user, plus an optional reason")
 	}
 
 	fmt.Println("- start mark_revision_needed")
 
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
 	
-	timestamp := strings.ToLower(args[0])
+	key := strings.ToLower(args[0])
 	newUser := strings.ToLower(args[1])
+	err = checkActor(stub, newUser)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
 
-	tradeAsBytes, err := stub.GetState(timestamp)					// get the trade
+	trade, err := getTrade(stub, key)						// get the trade
 	if err != nil {
-		return nil, errors.New("Failed to get value for key timestamp")
+		return nil, err
 	}
 
-	var trade Trade
-	json.Unmarshal(tradeAsBytes, &trade)
+	from := tradeStatus(trade)
+	err = moveTrade(stub, key, &trade, StatusNeedsRevision, newUser, reason)
+	if err != nil {
+		return nil, err
+	}
 
 	trade.User = newUser
-	trade.NeedsRevision = 1
 
-	bytes, _ := json.Marshal(trade)
+	err = putTrade(stub, key, trade)									// store trade under its key
+	if err != nil {
+		return nil, err
+	}
 
-	err = stub.PutState(timestamp, bytes)							// store trade with timestamp as key
+	err = emitTradeEvent(stub, EventTradeRevisionNeeded, key, from, tradeStatus(trade), newUser)
 	if err != nil {
 		return nil, err
 	}
@@ -372,42 +635,81 @@ func (t *SimpleChaincode) mark_revision_needed(stub *shim.ChaincodeStub, args []
 
 }
 
-// mark_revised - Mark a Trade in as revised
+// mark_revised - Mark a Trade in as revised, optionally amending its economics
+//                changes is a JSON object of field names to new values, e.g. {"price": "10.5", "valuedate": "2016-10-14"}
 // ============================================================================================================================
 func (t *SimpleChaincode) mark_revised(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 	
 	var err error
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 11")
+	//  0      1         2           3
+	// key, "user", "reason", "changes"
+	if len(args) < 2 || len(args) > 4 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, key and user, plus an optional reason and changes")
 	}
 
 	fmt.Println("- start mark_revised")
 
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
 	
-	timestamp := strings.ToLower(args[0])
+	key := strings.ToLower(args[0])
 	newUser := strings.ToLower(args[1])
+	err = checkActor(stub, newUser)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
 
-	tradeAsBytes, err := stub.GetState(timestamp)					// get the trade
+	trade, err := getTrade(stub, key)						// get the trade
+	if err != nil {
+		return nil, err
+	}
+	err = checkTradeOwner(stub, newUser, key, trade, nil)		// traders revise their own trades only
 	if err != nil {
-		return nil, errors.New("Failed to get value for key timestamp")
+		return nil, err
 	}
 
-	var trade Trade
-	json.Unmarshal(tradeAsBytes, &trade)
+	if len(args) > 3 && len(args[3]) > 0 {
+		var changes map[string]string
+		err = json.Unmarshal([]byte(args[3]), &changes)
+		if err != nil {
+			return nil, argError(ErrInvalidArgs, 3, "4th argument must be a JSON object of field names to new values")
+		}
+
+		err = amendTrade(stub, key, &trade, changes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = matchTrade(stub, key, &trade)							// the economics changed, match again
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	from := tradeStatus(trade)
+	err = moveTrade(stub, key, &trade, StatusRevised, newUser, reason)
+	if err != nil {
+		return nil, err
+	}
 
 	trade.User = newUser
-	trade.NeedsRevision = 0
 
-	bytes, _ := json.Marshal(trade)
+	err = putTrade(stub, key, trade)									// store trade under its key
+	if err != nil {
+		return nil, err
+	}
 
-	err = stub.PutState(timestamp, bytes)							// store trade with timestamp as key
+	err = emitTradeEvent(stub, EventTradeRevised, key, from, tradeStatus(trade), newUser)
 	if err != nil {
 		return nil, err
 	}
@@ -418,84 +720,306 @@ func (t *SimpleChaincode) mark_revised(stub *shim.ChaincodeStub, args []string)
 
 }
 
-// enrich_and_settle - Enrich a Trade and mark it as settled
+// enrich_trade - Enrich a Trade with its settlement details, it settles once a second user approves it
+//                enrichment is a JSON object, see Enrichment in trade_enrich.go
 // ============================================================================================================================
-func (t *SimpleChaincode) enrich_and_settle(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	
+func (t *SimpleChaincode) enrich_trade(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
 	var err error
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	//  0      1          2            3
+	// key, "user", "enrichment", "reason"
+	if len(args) < 3 || len(args) > 4 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 3, key, user and enrichment, plus an optional reason")
 	}
 
-	fmt.Println("- start enrich_and_settle")
+	fmt.Println("- start enrich_trade")
 
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
 	}
-	
-	timestamp := strings.ToLower(args[0])
+
+	key := strings.ToLower(args[0])
 	newUser := strings.ToLower(args[1])
+	err = checkActor(stub, newUser)
+	if err != nil {
+		return nil, err
+	}
 
-	tradeAsBytes, err := stub.GetState(timestamp)					// get the trade
+	enrichment, err := parseEnrichment(args[2])
 	if err != nil {
-		return nil, errors.New("Failed to get value for key timestamp")
+		return nil, err
 	}
 
-	var trade Trade
-	json.Unmarshal(tradeAsBytes, &trade)
+	if len(enrichment.Commission) > 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		commission, err := ParseDecimal(enrichment.Commission, config.AmountScale)
+		if err != nil {
+			return nil, argError(ErrInvalidArgs, 2, "commission " + err.Error())
+		}
+		enrichment.Commission = commission.String()
+	}
+
+	reason := ""
+	if len(args) > 3 {
+		reason = args[3]
+	}
+
+	trade, err := getTrade(stub, key)						// get the trade
+	if err != nil {
+		return nil, err
+	}
+
+	trade.Enrichment = enrichment
+	trade.EnrichedBy = newUser
+	trade.ApprovedBy = ""
+
+	from := tradeStatus(trade)
+	err = moveTrade(stub, key, &trade, StatusEnriched, newUser, reason)
+	if err != nil {
+		return nil, err
+	}
 
 	trade.User = newUser
-	trade.NeedsRevision = 0
-	trade.Settled = 1
 
-	bytes, _ := json.Marshal(trade)
+	err = putTrade(stub, key, trade)									// store trade under its key
+	if err != nil {
+		return nil, err
+	}
 
-	err = stub.PutState(timestamp, bytes)							// store trade with timestamp as key
+	err = emitTradeEvent(stub, EventTradeEnriched, key, from, tradeStatus(trade), newUser)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("- end enrich_and_settle")
+	fmt.Println("- end enrich_trade")
 
 	return nil, nil
 
 }
 
-// clear trades -- Clears all trades
+// approve_settlement - Approve an enriched Trade and mark it as settled
+//                      the approver must not be the user who enriched the trade
 // ============================================================================================================================
-func (t *SimpleChaincode) clear_all_trades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	
+func (t *SimpleChaincode) approve_settlement(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
 	var err error
-	var empty []string
 
-	err = stub.DelState(tradeIndexStr)
+	//  0      1         2
+	// key, "user", "reason"
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, key and user, plus an optional reason")
+	}
 
+	fmt.Println("- start approve_settlement")
+
+	if len(args[0]) <= 0 {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
+	}
+
+	key := strings.ToLower(args[0])
+	newUser := strings.ToLower(args[1])
+	err = checkActor(stub, newUser)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
+
+	trade, err := getTrade(stub, key)						// get the trade
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkFourEyes(key, trade, newUser)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMatched(key, trade)
 	if err != nil {
+		return nil, err
+	}
+	trade.ApprovedBy = newUser
 
+	from := tradeStatus(trade)
+	err = moveTrade(stub, key, &trade, StatusSettled, newUser, reason)
+	if err != nil {
 		return nil, err
+	}
 
-	} else {
+	trade.User = newUser
 
-		// reset the value of tradeIndexStr, marshal an empty array of strings
-		jsonAsBytes, _ := json.Marshal(empty)
-		err = stub.PutState(tradeIndexStr, jsonAsBytes)
+	err = putTrade(stub, key, trade)									// store trade under its key
+	if err != nil {
+		return nil, err
+	}
 
+	err = emitTradeEvent(stub, EventTradeSettled, key, from, tradeStatus(trade), newUser)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end approve_settlement")
+
+	return nil, nil
+
+}
+
+// ============================================================================================================================
+// checkFourEyes - only an enriched trade can be approved, and never by the user who enriched it
+// ============================================================================================================================
+func checkFourEyes(key string, trade Trade, approver string) error {
+	status := tradeStatus(trade)
+	if status != StatusEnriched {
+		return newError(ErrIllegalTransition, "Trade " + key + " is " + status + ", only enriched trades can be approved")
+	}
+	if trade.EnrichedBy == "" {
+		return newError(ErrIllegalTransition, "Trade " + key + " has no recorded enricher, enrich it again before approval")
+	}
+	if trade.EnrichedBy == approver {
+		return newError(ErrUnauthorized, "Trade " + key + " was enriched by " + approver + ", settlement must be approved by a different user")
+	}
+	return nil
+}
+
+// clear trades -- Clears all trades, or only the trades matching a filter
+//                 "delete" removes them with their history and versions, "archive" moves them to the archive namespace
+// ============================================================================================================================
+func (t *SimpleChaincode) clear_all_trades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	
+	var err error
+
+	//     0        1
+	// "archive", filter
+	if len(args) > 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an optional mode, delete or archive, and an optional JSON filter")
+	}
+
+	fmt.Println("- start clear_all_trades")
+
+	mode := "delete"
+	if len(args) > 0 && len(args[0]) > 0 {
+		mode = strings.ToLower(args[0])
+	}
+	if mode != "delete" && mode != "archive" {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be delete or archive")
+	}
+
+	filter := TradeFilter{}
+	if len(args) > 1 {
+		filter, err = parseTradeFilter(args[1])
 		if err != nil {
+			return nil, err
+		}
+	}
+
+	// cancelled trades are only in the cancelled index, a filter without a status can match trades in either
+	indexes := []string{tradeIndexStr, cancelledTradeIndexStr}
+	if filter.Status == StatusCancelled {
+		indexes = []string{cancelledTradeIndexStr}
+	} else if filter.Status != "" {
+		indexes = []string{tradeIndexStr}
+	}
 
+	report := ClearReport{}
+	report.Mode = mode
+	report.Keys = []string{}
+	for _, name := range indexes {
+		index, err := getIndex(stub, name)
+		if err != nil {
 			return nil, err
+		}
 
-		} else {
+		var kept []string
+		for _, key := range index {
+			trade, err := getTrade(stub, key)
+			if errorCode(err) == ErrNotFound {
+				continue												// keys without a trade are dropped from the index
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !filter.matches(trade) {
+				kept = append(kept, key)
+				continue
+			}
+
+			err = removeTrade(stub, key, mode == "archive", "cleared")
+			if err != nil {
+				return nil, err
+			}
+			report.Keys = append(report.Keys, key)
+		}
 
-			return nil, nil
+		err = putIndex(stub, name, kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Cleared = len(report.Keys)
 
+	if mode == "archive" && report.Cleared > 0 {
+		archivedIndex, err := getIndex(stub, archivedTradeIndexStr)
+		if err != nil {
+			return nil, err
+		}
+		err = putIndex(stub, archivedTradeIndexStr, append(archivedIndex, report.Keys...))
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	fmt.Println("- end clear_all_trades")
+	return json.Marshal(report)
+
+}
+
+// stateReader is the part of the stub reading a record needs, *shim.ChaincodeStub satisfies it and tests read from a map
+type stateReader interface {
+	GetState(key string) ([]byte, error)
+}
+
+// ============================================================================================================================
+// getTrade - read a trade from chaincode state, error if it does not exist
+// ============================================================================================================================
+func getTrade(stub stateReader, key string) (Trade, error) {
+	var trade Trade
 
+	tradeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return trade, newError(ErrInternal, "Failed to get value for key " + key)
+	}
+	if len(tradeAsBytes) == 0 {
+		return trade, newError(ErrNotFound, "No trade found for key " + key)
 	}
 
+	trade, err = decodeTrade(tradeAsBytes)								// any schema version, see trade_schema.go
+	if err != nil {
+		return trade, newError(ErrInternal, "Trade " + key + ": " + err.Error())
+	}
+	trade.Status = tradeStatus(trade)									// map records stored before Status existed
+	return trade, nil
+}
+
+// ============================================================================================================================
+// putTrade - write a trade into chaincode state under its key
+// ============================================================================================================================
+func putTrade(stub *shim.ChaincodeStub, key string, trade Trade) error {
+	trade.Schema = tradeSchemaVersion
+	jsonAsBytes, _ := json.Marshal(trade)
+	return stub.PutState(key, jsonAsBytes)
 }
 
 // ============================================================================================================================
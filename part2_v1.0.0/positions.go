@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var positionPrefix = "_position_"				//prefix for the key/value that stores a user's holding in a security
+var positionIndexStr = "_positionindex"			//name for the key/value that will store a list of all position keys
+
+type Position struct {
+	User string `json:"user"`
+	Security string `json:"security"`
+	Quantity int `json:"quantity"`					// net settled quantity, buys add and sells subtract
+	NetCash string `json:"netcash"`				// net settled consideration, sells add and buys subtract
+}
+
+// ============================================================================================================================
+// positionKey - key a user's position in a security is stored under
+// ============================================================================================================================
+func positionKey(user string, security string) string {
+	return positionPrefix + user + "/" + security
+}
+
+// ============================================================================================================================
+// tradeOwner - user whose book a trade settles into, trades stored before Trader existed fall back to User
+// ============================================================================================================================
+func tradeOwner(trade Trade) string {
+	if trade.Trader != "" {
+		return trade.Trader
+	}
+	return trade.User
+}
+
+// ============================================================================================================================
+// getPosition - read a position, a flat position if none has been stored yet
+// ============================================================================================================================
+func getPosition(stub *shim.ChaincodeStub, user string, security string) (Position, bool, error) {
+	position := Position{}
+	position.User = user
+	position.Security = security
+
+	positionAsBytes, err := stub.GetState(positionKey(user, security))
+	if err != nil {
+		return position, false, newError(ErrInternal, "Failed to get position for " + user + " in " + security)
+	}
+	if len(positionAsBytes) == 0 {
+		return position, false, nil
+	}
+
+	json.Unmarshal(positionAsBytes, &position)						// un stringify it aka JSON.parse()
+	return position, true, nil
+}
+
+// ============================================================================================================================
+// bookPosition - add a settling trade's quantity and consideration to a position, buys add securities and pay cash
+// ============================================================================================================================
+func bookPosition(position *Position, trade Trade, amountScale int) error {
+	var err error
+
+	netCash := NewDecimal(0, amountScale)
+	if len(position.NetCash) > 0 {
+		netCash, err = ParseDecimal(position.NetCash, amountScale)
+		if err != nil {
+			return newError(ErrInternal, "Stored net cash for " + position.User + " in " + position.Security + " " + err.Error())
+		}
+	}
+	consideration, err := ParseDecimal(trade.Consideration, amountScale)
+	if err != nil {
+		return newError(ErrInternal, "Consideration of trade " + trade.ID + " " + err.Error())
+	}
+
+	switch trade.Operation {
+	case "buy":
+		position.Quantity += trade.Quantity
+		netCash = netCash.Add(consideration.Neg())
+	case "sell":
+		position.Quantity -= trade.Quantity
+		netCash = netCash.Add(consideration)
+	default:
+		return newError(ErrInternal, "Operation " + trade.Operation + " cannot be settled, expecting buy or sell")
+	}
+	position.NetCash = netCash.String()
+	return nil
+}
+
+// ============================================================================================================================
+// applyPosition - book a settling trade into its owner's position
+// ============================================================================================================================
+func applyPosition(stub *shim.ChaincodeStub, trade Trade) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	owner := tradeOwner(trade)
+	position, exists, err := getPosition(stub, owner, trade.Security)
+	if err != nil {
+		return err
+	}
+
+	err = bookPosition(&position, trade, config.AmountScale)
+	if err != nil {
+		return err
+	}
+
+	jsonAsBytes, _ := json.Marshal(position)
+	err = stub.PutState(positionKey(owner, trade.Security), jsonAsBytes)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		positionIndex, err := getIndex(stub, positionIndexStr)
+		if err != nil {
+			return err
+		}
+		err = putIndex(stub, positionIndexStr, append(positionIndex, positionKey(owner, trade.Security)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// get_position - return a user's position in a security
+// ============================================================================================================================
+func (t *SimpleChaincode) get_position(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, user and security")
+	}
+
+	position, _, err := getPosition(stub, strings.ToLower(args[0]), strings.ToLower(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	if len(position.NetCash) == 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		position.NetCash = NewDecimal(0, config.AmountScale).String()
+	}
+	return json.Marshal(position)
+}
+
+// ============================================================================================================================
+// list_positions - return every position, or only one user's positions
+// ============================================================================================================================
+func (t *SimpleChaincode) list_positions(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an optional user")
+	}
+
+	fmt.Println("- start list_positions")
+
+	user := ""
+	if len(args) == 1 {
+		user = strings.ToLower(args[0])
+	}
+
+	positionIndex, err := getIndex(stub, positionIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := []Position{}
+	for _, key := range positionIndex {
+		positionAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, newError(ErrInternal, "Failed to get value for key " + key)
+		}
+		position := Position{}
+		json.Unmarshal(positionAsBytes, &position)					// un stringify it aka JSON.parse()
+		if user == "" || position.User == user {
+			positions = append(positions, position)
+		}
+	}
+
+	fmt.Println("- end list_positions")
+	return json.Marshal(positions)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+// baselineTrade is a trade as the original part2 chaincode stored it, before ids, pricing and positions
+var baselineTrade = `{"tradedate":"2016-10-14","valuedate":"2016-10-18","operation":"buy","quantity":10,"security":"ibm",` +
+	`"price":"2.5","counterparty":"cp","user":"bob","timestamp":1476403200000,"settled":0,"needsrevision":0}`
+
+func TestBookPosition(t *testing.T) {
+	position := Position{User: "bob", Security: "ibm"}
+
+	buy := Trade{ID: "trade_a_0", Operation: "buy", Quantity: 10, Consideration: "25.00"}
+	err := bookPosition(&position, buy, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sell := Trade{ID: "trade_b_0", Operation: "sell", Quantity: 4, Consideration: "10.50"}
+	err = bookPosition(&position, sell, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Quantity != 6 || position.NetCash != "-14.50" {
+		t.Errorf("position is %d against %s, want 6 against -14.50", position.Quantity, position.NetCash)
+	}
+
+	err = bookPosition(&position, Trade{ID: "trade_c_0", Operation: "buy", Quantity: 1}, 2)
+	if errorCode(err) != ErrInternal {
+		t.Errorf("trade without a consideration booked, got %v", err)
+	}
+}
+
+func TestBookBaselineTrade(t *testing.T) {
+	trade, err := decodeTrade([]byte(baselineTrade))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.Quantity != 10 || trade.Consideration != "" {
+		t.Fatalf("baseline trade decoded as %+v", trade)
+	}
+
+	// what backfillTrade does when a baseline trade settles, with the default settings and no security master entry
+	trade.ID = "a"
+	err = priceTradeWith(&trade, defaultConfig(), NewDecimal(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.Price != "2.500000" || trade.Consideration != "25.00" {
+		t.Errorf("baseline trade priced at %s for %s, want 2.500000 for 25.00", trade.Price, trade.Consideration)
+	}
+
+	position := Position{User: tradeOwner(trade), Security: trade.Security}
+	err = bookPosition(&position, trade, defaultConfig().AmountScale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.User != "bob" || position.Quantity != 10 || position.NetCash != "-25.00" {
+		t.Errorf("baseline trade booked as %+v", position)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var securityPrefix = "_security_"				//prefix for the key/value that stores an instrument
+var securityIndexStr = "_securityindex"			//name for the key/value that will store a list of all security ids
+
+var securityScale = 8							//decimal places allowed in multipliers and tick sizes
+
+type Security struct {
+	ID string `json:"id"`							// identifier used on trades, lowercase
+	IDType string `json:"idtype"`					// isin, cusip or ticker
+	InstrumentType string `json:"instrumenttype"`	// e.g. equity, bond
+	Currency string `json:"currency"`				// ISO 4217 code
+	PriceMultiplier string `json:"pricemultiplier"`	// consideration is quantity x price x multiplier
+	TickSize string `json:"ticksize"`				// prices must be a whole number of ticks
+}
+
+// ============================================================================================================================
+// getSecurity - read an instrument from the security master, error if it is unknown
+// ============================================================================================================================
+func getSecurity(stub stateReader, id string) (Security, error) {
+	var security Security
+
+	securityAsBytes, err := stub.GetState(securityPrefix + id)
+	if err != nil {
+		return security, newError(ErrInternal, "Failed to get security " + id)
+	}
+	if len(securityAsBytes) == 0 {
+		return security, newError(ErrNotFound, "Unknown security " + id)
+	}
+
+	json.Unmarshal(securityAsBytes, &security)						// un stringify it aka JSON.parse()
+	return security, nil
+}
+
+// ============================================================================================================================
+// checkSecurity - a trade may only be booked on a known security at a price on its tick grid
+// ============================================================================================================================
+func checkSecurity(stub *shim.ChaincodeStub, id string, price string) error {
+	security, err := getSecurity(stub, id)
+	if err != nil {
+		return err
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	tradePrice, err := ParseDecimal(price, config.PriceScale)
+	if err != nil {
+		return newError(ErrInvalidArgs, "price " + err.Error())
+	}
+	tickSize, _ := ParseDecimal(security.TickSize, securityScale)
+	if !tradePrice.IsMultipleOf(tickSize) {
+		return newError(ErrInvalidArgs, "price " + price + " is not on the tick grid of " + id + ", tick size " + security.TickSize)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// priceMultiplier - multiplier of a security, 1 for securities not in the security master
+// ============================================================================================================================
+func priceMultiplier(stub *shim.ChaincodeStub, id string) (Decimal, error) {
+	security, err := getSecurity(stub, id)
+	if errorCode(err) == ErrNotFound {
+		return NewDecimal(1, 0), nil
+	}
+	if err != nil {
+		return Decimal{}, err
+	}
+	return ParseDecimal(security.PriceMultiplier, securityScale)
+}
+
+// ============================================================================================================================
+// parseSecurity - un stringify and validate a security argument
+// ============================================================================================================================
+func parseSecurity(arg string) (Security, error) {
+	var security Security
+
+	err := json.Unmarshal([]byte(arg), &security)
+	if err != nil {
+		return security, newError(ErrInvalidArgs, "Security must be a JSON object with id, idtype, instrumenttype, currency, pricemultiplier and ticksize")
+	}
+
+	security.ID = strings.TrimSpace(security.ID)
+	security.IDType = strings.ToLower(security.IDType)
+	security.InstrumentType = strings.ToLower(strings.TrimSpace(security.InstrumentType))
+	security.Currency = strings.ToUpper(strings.TrimSpace(security.Currency))
+	if security.PriceMultiplier == "" {
+		security.PriceMultiplier = "1"
+	}
+
+	switch security.IDType {
+	case "isin":
+		if !validISIN(strings.ToUpper(security.ID)) {
+			return security, newError(ErrInvalidArgs, "Security id " + security.ID + " is not a valid ISIN")
+		}
+	case "cusip":
+		if !validCUSIP(strings.ToUpper(security.ID)) {
+			return security, newError(ErrInvalidArgs, "Security id " + security.ID + " is not a valid CUSIP")
+		}
+	case "ticker":
+		if len(security.ID) == 0 || len(security.ID) > 12 || strings.ContainsAny(security.ID, " \t/") {
+			return security, newError(ErrInvalidArgs, "Security id " + security.ID + " is not a valid ticker")
+		}
+	default:
+		return security, newError(ErrInvalidArgs, "Security idtype must be isin, cusip or ticker")
+	}
+	security.ID = strings.ToLower(security.ID)						// trades store securities lowercased
+
+	if len(security.InstrumentType) == 0 {
+		return security, newError(ErrInvalidArgs, "Security instrumenttype must be a non-empty string")
+	}
+	if len(security.Currency) != 3 || !isUpperLetters(security.Currency) {
+		return security, newError(ErrInvalidArgs, "Security currency must be a 3 letter ISO 4217 code")
+	}
+
+	multiplier, err := ParseDecimal(security.PriceMultiplier, securityScale)
+	if err != nil || multiplier.Sign() <= 0 {
+		return security, newError(ErrInvalidArgs, "Security pricemultiplier must be a decimal greater than 0")
+	}
+	tickSize, err := ParseDecimal(security.TickSize, securityScale)
+	if err != nil || tickSize.Sign() <= 0 {
+		return security, newError(ErrInvalidArgs, "Security ticksize must be a decimal greater than 0")
+	}
+	return security, nil
+}
+
+// ============================================================================================================================
+// validISIN - 2 letter country code, 9 alphanumerics and a Luhn check digit over the letters expanded to numbers
+// ============================================================================================================================
+func validISIN(isin string) bool {
+	if len(isin) != 12 || !isUpperLetters(isin[:2]) || !isDigits(isin[11:]) {
+		return false
+	}
+
+	var digits string
+	for _, c := range isin {
+		switch {
+		case c >= '0' && c <= '9':
+			digits += string(c)
+		case c >= 'A' && c <= 'Z':
+			digits += strconv.Itoa(int(c - 'A') + 10)					// A = 10 ... Z = 35
+		default:
+			return false
+		}
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {						// Luhn, doubling every second digit from the right
+		n := int(digits[i] - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+	return sum % 10 == 0
+}
+
+// ============================================================================================================================
+// validCUSIP - 8 characters and a modulus 10 double-add-double check digit
+// ============================================================================================================================
+func validCUSIP(cusip string) bool {
+	if len(cusip) != 9 || !isDigits(cusip[8:]) {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 8; i++ {
+		var v int
+		c := cusip[i]
+		switch {
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'A' && c <= 'Z':
+			v = int(c - 'A') + 10
+		case c == '*':
+			v = 36
+		case c == '@':
+			v = 37
+		case c == '#':
+			v = 38
+		default:
+			return false
+		}
+		if i % 2 == 1 {
+			v *= 2
+		}
+		sum += v / 10 + v % 10
+	}
+	return (10 - sum % 10) % 10 == int(cusip[8] - '0')
+}
+
+func isUpperLetters(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================================================================
+// putSecurity - write an instrument into the security master
+// ============================================================================================================================
+func putSecurity(stub *shim.ChaincodeStub, security Security) error {
+	jsonAsBytes, _ := json.Marshal(security)
+	return stub.PutState(securityPrefix + security.ID, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// add_security - add a new instrument to the security master
+// ============================================================================================================================
+func (t *SimpleChaincode) add_security(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, a JSON security")
+	}
+
+	fmt.Println("- start add_security")
+
+	security, err := parseSecurity(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getSecurity(stub, security.ID)
+	if err == nil {
+		return nil, newError(ErrConflict, "Security " + security.ID + " already exists")
+	}
+
+	err = putSecurity(stub, security)
+	if err != nil {
+		return nil, err
+	}
+
+	securityIndex, err := getIndex(stub, securityIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	err = putIndex(stub, securityIndexStr, append(securityIndex, security.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end add_security")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// update_security - replace the details of an instrument in the security master
+// ============================================================================================================================
+func (t *SimpleChaincode) update_security(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1, a JSON security")
+	}
+
+	fmt.Println("- start update_security")
+
+	security, err := parseSecurity(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getSecurity(stub, security.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putSecurity(stub, security)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end update_security")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// get_security - return one instrument from the security master
+// ============================================================================================================================
+func (t *SimpleChaincode) get_security(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting id of the security")
+	}
+
+	security, err := getSecurity(stub, strings.ToLower(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(security)
+}
+
+// ============================================================================================================================
+// list_securities - return every instrument in the security master
+// ============================================================================================================================
+func (t *SimpleChaincode) list_securities(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	securityIndex, err := getIndex(stub, securityIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	securities := []Security{}
+	for _, id := range securityIndex {
+		security, err := getSecurity(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		securities = append(securities, security)
+	}
+	return json.Marshal(securities)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Settlement instruction formats
+const (
+	FormatISO15022 = "iso15022"			// MT541 receive against payment, MT543 deliver against payment
+	FormatISO20022 = "iso20022"			// sese.023 securities settlement transaction instruction
+)
+
+var bicPattern = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+
+// Instruction is what a settlement instruction says, independent of the message format it is rendered in
+type Instruction struct {
+	Reference string						// 16 characters, unique per trade or netting set
+	Receive bool							// true to receive securities and pay cash, false to deliver and be paid
+	TradeDate string
+	SettlementDate string
+	Price string							// deal price, empty for netting sets
+	Security Security
+	Quantity int							// always positive, Receive gives the direction
+	Amount string							// settlement amount, always positive
+	Enrichment Enrichment					// our side of the settlement
+	Counterparty Counterparty				// their side of the settlement
+}
+
+// ============================================================================================================================
+// instructionReference - 16 character reference derived from a ledger key, SWIFT references cannot hold the key itself
+// ============================================================================================================================
+func instructionReference(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))[:16]
+}
+
+// ============================================================================================================================
+// tradeInstruction - instruction for one settled trade
+// ============================================================================================================================
+func tradeInstruction(stub stateReader, key string) (Instruction, error) {
+	var instruction Instruction
+
+	trade, err := getTrade(stub, key)
+	if err != nil {
+		return instruction, err
+	}
+	if tradeStatus(trade) != StatusSettled {
+		return instruction, newError(ErrRejected, "Trade " + key + " is " + tradeStatus(trade) + ", only settled trades can be instructed")
+	}
+	if trade.NettingSet != "" {
+		return instruction, newError(ErrRejected, "Trade " + key + " settled in netting set " + trade.NettingSet + ", instruct the netting set instead")
+	}
+
+	if trade.Enrichment == nil {
+		return instruction, newError(ErrRejected, "Trade " + key + " settled without enrichment and cannot be instructed")
+	}
+
+	instruction.Reference = instructionReference(key)
+	instruction.Receive = trade.Operation == "buy"
+	instruction.TradeDate = trade.TradeDate
+	instruction.SettlementDate = trade.ValueDate
+	instruction.Price = trade.Price
+	instruction.Quantity = trade.Quantity
+	instruction.Amount = trade.Consideration
+	instruction.Enrichment = *trade.Enrichment
+
+	return instruction, instructionParties(stub, &instruction, trade.Security, trade.Counterparty)
+}
+
+// ============================================================================================================================
+// nettingSetDirection - true when a netting set receives securities and pays cash, false when it delivers and is paid
+//                       a set that receives and is paid, delivers and pays, or nets to no cash cannot settle against
+//                       payment, it needs a free of payment instruction and a separate payment
+// ============================================================================================================================
+func nettingSetDirection(key string, set NettingSet) (bool, error) {
+	receive := set.NetQuantity > 0
+	pays := strings.HasPrefix(set.NetCash, "-")
+	if receive != pays || strings.Trim(set.NetCash, "-0.") == "" {
+		return false, newError(ErrRejected, "Netting set " + key + " nets to a quantity of " + strconv.Itoa(set.NetQuantity) + " against cash of " + set.NetCash + ", it cannot settle against payment")
+	}
+	return receive, nil
+}
+
+// ============================================================================================================================
+// nettingSetInstruction - instruction for the net movement of a netting set
+// ============================================================================================================================
+func nettingSetInstruction(stub stateReader, key string) (Instruction, error) {
+	var instruction Instruction
+
+	set, err := getNettingSet(stub, key)
+	if err != nil {
+		return instruction, err
+	}
+	if set.NetQuantity == 0 {
+		return instruction, newError(ErrRejected, "Netting set " + key + " nets to a quantity of 0, there are no securities to instruct")
+	}
+
+	// the trades of a set may have been enriched separately, they must settle through the same accounts
+	for i, tradeKey := range set.TradeKeys {
+		trade, err := getTrade(stub, tradeKey)
+		if err != nil {
+			return instruction, err
+		}
+		if trade.Enrichment == nil {
+			return instruction, newError(ErrRejected, "Trade " + tradeKey + " settled without enrichment and cannot be instructed")
+		}
+		if i == 0 {
+			instruction.Enrichment = *trade.Enrichment
+		} else if trade.Enrichment.SettlementAccount != instruction.Enrichment.SettlementAccount || trade.Enrichment.PlaceOfSettlement != instruction.Enrichment.PlaceOfSettlement {
+			return instruction, newError(ErrRejected, "Netting set " + key + " spans more than one settlement account or place of settlement")
+		}
+		if trade.TradeDate > instruction.TradeDate {					// latest trade date of the set
+			instruction.TradeDate = trade.TradeDate
+		}
+	}
+	instruction.Enrichment.Commission = ""
+
+	receive, err := nettingSetDirection(key, set)
+	if err != nil {
+		return instruction, err
+	}
+
+	instruction.Reference = instructionReference(key)
+	instruction.Receive = receive
+	instruction.SettlementDate = set.ValueDate
+	instruction.Quantity = set.NetQuantity
+	if instruction.Quantity < 0 {
+		instruction.Quantity = -instruction.Quantity
+	}
+	instruction.Amount = strings.TrimPrefix(set.NetCash, "-")
+
+	return instruction, instructionParties(stub, &instruction, set.Security, set.Counterparty)
+}
+
+// ============================================================================================================================
+// instructionParties - add the security and counterparty details an instruction needs
+// ============================================================================================================================
+func instructionParties(stub stateReader, instruction *Instruction, securityID string, counterpartyID string) error {
+	security, err := getSecurity(stub, securityID)
+	if err != nil {
+		return err
+	}
+	counterparty, err := getCounterparty(stub, counterpartyID)
+	if err != nil {
+		return err
+	}
+	if counterparty.Settlement.Custodian == "" || counterparty.Settlement.Account == "" {
+		return newError(ErrRejected, "Counterparty " + counterpartyID + " has no settlement custodian and account")
+	}
+
+	instruction.Security = security
+	instruction.Counterparty = counterparty
+	return nil
+}
+
+// ============================================================================================================================
+// renderMT54x - render an instruction as the text block of an MT541 when receiving or an MT543 when delivering
+//               the sender adds the basic and application header blocks
+// ============================================================================================================================
+func renderMT54x(instruction Instruction) string {
+	agent, party := "DEAG", "SELL"								// MT541, they deliver and we receive
+	if !instruction.Receive {
+		agent, party = "REAG", "BUYR"							// MT543, they receive and we deliver
+	}
+
+	lines := []string{
+		"{4:",
+		":16R:GENL",
+		":20C::SEME//" + instruction.Reference,
+		":23G:NEWM",
+		":16S:GENL",
+		":16R:TRADDET",
+		":98A::SETT//" + swiftDate(instruction.SettlementDate),
+		":98A::TRAD//" + swiftDate(instruction.TradeDate),
+	}
+	if instruction.Price != "" {
+		lines = append(lines, ":90B::DEAL//ACTU/" + instruction.Security.Currency + swiftDecimal(instruction.Price))
+	}
+	lines = append(lines,
+		":35B:" + swiftSecurity(instruction.Security),
+		":70E::SPRO//SSI " + instruction.Enrichment.SSIReference,
+		":16S:TRADDET",
+		":16R:FIAC",
+		":36B::SETT//UNIT/" + swiftDecimal(strconv.Itoa(instruction.Quantity)),
+		":97A::SAFE//" + instruction.Enrichment.SettlementAccount,
+		":16S:FIAC",
+		":16R:SETDET",
+		":22F::SETR//TRAD",
+		":16R:SETPRTY",
+		swiftParty("PSET", instruction.Enrichment.PlaceOfSettlement),
+		":16S:SETPRTY",
+		":16R:SETPRTY",
+		swiftParty(agent, instruction.Counterparty.Settlement.Custodian),
+		":97A::SAFE//" + instruction.Counterparty.Settlement.Account,
+		":16S:SETPRTY",
+		":16R:SETPRTY",
+		":95L::" + party + "//" + instruction.Counterparty.LEI,
+		":16S:SETPRTY",
+		":16R:AMT",
+		":19A::SETT//" + instruction.Security.Currency + swiftDecimal(instruction.Amount),
+		":16S:AMT",
+		":16S:SETDET",
+		"-}",
+	)
+	return strings.Join(lines, "\r\n")
+}
+
+// ============================================================================================================================
+// swiftDate - YYYYMMDD from an ISO date
+// ============================================================================================================================
+func swiftDate(date string) string {
+	return strings.Replace(date, "-", "", -1)
+}
+
+// ============================================================================================================================
+// swiftDecimal - SWIFT decimal, a comma for the decimal point, no trailing zeros and no sign, e.g. "2.500000" is "2,5"
+// ============================================================================================================================
+func swiftDecimal(value string) string {
+	value = strings.TrimPrefix(value, "-")
+	if strings.Contains(value, ".") {
+		value = strings.TrimRight(strings.TrimRight(value, "0"), ".")
+	}
+	if strings.Contains(value, ".") {
+		return strings.Replace(value, ".", ",", 1)
+	}
+	return value + ","
+}
+
+// ============================================================================================================================
+// swiftSecurity - field 35B identification, an ISIN or a description naming the identifier type
+// ============================================================================================================================
+func swiftSecurity(security Security) string {
+	id := strings.ToUpper(security.ID)
+	switch security.IDType {
+	case "isin":
+		return "ISIN " + id
+	case "cusip":
+		return "/US/" + id
+	}
+	return "/TS/" + id
+}
+
+// ============================================================================================================================
+// swiftParty - party field, 95P when the party is given as a BIC and 95Q with its name otherwise
+// ============================================================================================================================
+func swiftParty(qualifier string, party string) string {
+	if bicPattern.MatchString(strings.ToUpper(party)) {
+		return ":95P::" + qualifier + "//" + strings.ToUpper(party)
+	}
+	return ":95Q::" + qualifier + "//" + party
+}
+
+// sese.023 document, only the elements an instruction fills in
+type Sese023Document struct {
+	XMLName xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:sese.023.001.09 Document"`
+	Instr Sese023Instruction `xml:"SctiesSttlmTxInstr"`
+}
+
+type Sese023Instruction struct {
+	TxId string `xml:"TxId"`
+	MvmntTp string `xml:"SttlmTpAndAddtlParams>SctiesMvmntTp"`		// RECE or DELI
+	Pmt string `xml:"SttlmTpAndAddtlParams>Pmt"`						// APMT, against payment
+	TradDt string `xml:"TradDtls>TradDt>Dt>Dt"`
+	SttlmDt string `xml:"TradDtls>SttlmDt>Dt>Dt"`
+	DealPric *Sese023Amount `xml:"TradDtls>DealPric>Val>Amt,omitempty"`
+	ISIN string `xml:"FinInstrmId>ISIN,omitempty"`
+	OthrId *Sese023OtherID `xml:"FinInstrmId>OthrId,omitempty"`
+	Qty int `xml:"QtyAndAcctDtls>SttlmQty>Qty>Unit"`
+	SfkpgAcct string `xml:"QtyAndAcctDtls>SfkpgAcct>Id"`
+	SctiesTxTp string `xml:"SttlmParams>SctiesTxTp>Cd"`
+	Dlvrg *Sese023Parties `xml:"DlvrgSttlmPties,omitempty"`
+	Rcvg *Sese023Parties `xml:"RcvgSttlmPties,omitempty"`
+	SttlmAmt Sese023Amount `xml:"SttlmAmt>Amt"`
+	CdtDbtInd string `xml:"SttlmAmt>CdtDbtInd"`						// DBIT when we pay, CRDT when we are paid
+	SSIRef string `xml:"AddtlTxRef>SSIRef,omitempty"`
+}
+
+type Sese023Amount struct {
+	Ccy string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type Sese023OtherID struct {
+	Id string `xml:"Id"`
+	Tp string `xml:"Tp>Prtry"`
+}
+
+type Sese023Party struct {
+	Id Sese023PartyID `xml:"Id"`
+	LEI string `xml:"LEI,omitempty"`
+	SfkpgAcct *Sese023Account `xml:"SfkpgAcct,omitempty"`
+}
+
+type Sese023PartyID struct {
+	AnyBIC string `xml:"AnyBIC,omitempty"`
+	NmAndAdr *Sese023Name `xml:"NmAndAdr,omitempty"`
+}
+
+type Sese023Name struct {
+	Nm string `xml:"Nm"`
+}
+
+type Sese023Account struct {
+	Id string `xml:"Id"`
+}
+
+type Sese023Parties struct {
+	Dpstry Sese023Party `xml:"Dpstry"`								// place of settlement
+	Pty1 Sese023Party `xml:"Pty1"`									// the counterparty's custodian
+	Pty2 Sese023Party `xml:"Pty2"`									// the counterparty itself
+}
+
+// ============================================================================================================================
+// sese023Party - party identified by BIC when it is one, by name otherwise
+// ============================================================================================================================
+func sese023Party(party string) Sese023Party {
+	if bicPattern.MatchString(strings.ToUpper(party)) {
+		return Sese023Party{Id: Sese023PartyID{AnyBIC: strings.ToUpper(party)}}
+	}
+	return Sese023Party{Id: Sese023PartyID{NmAndAdr: &Sese023Name{party}}}
+}
+
+// ============================================================================================================================
+// renderSese023 - render an instruction as a sese.023 XML document
+// ============================================================================================================================
+func renderSese023(instruction Instruction) (string, error) {
+	instr := Sese023Instruction{}
+	instr.TxId = instruction.Reference
+	instr.Pmt = "APMT"
+	instr.TradDt = instruction.TradeDate
+	instr.SttlmDt = instruction.SettlementDate
+	if instruction.Price != "" {
+		instr.DealPric = &Sese023Amount{instruction.Security.Currency, instruction.Price}
+	}
+	if instruction.Security.IDType == "isin" {
+		instr.ISIN = strings.ToUpper(instruction.Security.ID)
+	} else {
+		instr.OthrId = &Sese023OtherID{strings.ToUpper(instruction.Security.ID), strings.ToUpper(instruction.Security.IDType)}
+	}
+	instr.Qty = instruction.Quantity
+	instr.SfkpgAcct = instruction.Enrichment.SettlementAccount
+	instr.SctiesTxTp = "TRAD"
+	instr.SttlmAmt = Sese023Amount{instruction.Security.Currency, instruction.Amount}
+	instr.SSIRef = instruction.Enrichment.SSIReference
+
+	parties := &Sese023Parties{}
+	parties.Dpstry = sese023Party(instruction.Enrichment.PlaceOfSettlement)
+	parties.Pty1 = sese023Party(instruction.Counterparty.Settlement.Custodian)
+	parties.Pty1.SfkpgAcct = &Sese023Account{instruction.Counterparty.Settlement.Account}
+	parties.Pty2 = sese023Party(instruction.Counterparty.LegalName)
+	parties.Pty2.LEI = instruction.Counterparty.LEI
+
+	if instruction.Receive {
+		instr.MvmntTp = "RECE"
+		instr.CdtDbtInd = "DBIT"
+		instr.Dlvrg = parties										// they deliver to us
+	} else {
+		instr.MvmntTp = "DELI"
+		instr.CdtDbtInd = "CRDT"
+		instr.Rcvg = parties										// they receive from us
+	}
+
+	xmlAsBytes, err := xml.MarshalIndent(Sese023Document{Instr: instr}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(xmlAsBytes), nil
+}
+
+// ============================================================================================================================
+// settlement_instruction - render a settled trade or a netting set as a settlement instruction
+//                          format is iso15022 for MT541/MT543 or iso20022 for sese.023
+// ============================================================================================================================
+func (t *SimpleChaincode) settlement_instruction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//  0       1
+	// key, "format"
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, key of a trade or netting set and format")
+	}
+
+	key := strings.ToLower(args[0])
+	format := strings.ToLower(args[1])
+	if format != FormatISO15022 && format != FormatISO20022 {
+		return nil, argError(ErrInvalidArgs, 1, "Format must be " + FormatISO15022 + " or " + FormatISO20022)
+	}
+
+	var instruction Instruction
+	var err error
+	if strings.HasPrefix(key, nettingSetPrefix) {
+		instruction, err = nettingSetInstruction(stub, key)
+	} else {
+		instruction, err = tradeInstruction(stub, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatISO15022 {
+		return []byte(renderMT54x(instruction)), nil
+	}
+	message, err := renderSese023(instruction)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(message), nil
+}
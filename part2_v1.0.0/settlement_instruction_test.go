@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// stateMap stands in for the ledger when a function only reads it
+type stateMap map[string][]byte
+
+func (m stateMap) GetState(key string) ([]byte, error) {
+	return m[key], nil
+}
+
+// ============================================================================================================================
+// put - store a record as the chaincode would, JSON encoded
+// ============================================================================================================================
+func (m stateMap) put(key string, record interface{}) {
+	m[key], _ = json.Marshal(record)
+}
+
+var testTradeKey = "trade1"
+var testNettingSetKey = nettingSetPrefix + "cp_ibm_2017-03-03"
+
+// ============================================================================================================================
+// testTrade - a settled buy of 1500 shares, stored under testTradeKey
+// ============================================================================================================================
+func testTrade() Trade {
+	return Trade{Schema: tradeSchemaVersion, ID: testTradeKey, TradeDate: "2017-03-01", ValueDate: "2017-03-03", Operation: "buy", Quantity: 1500,
+		Security: "us4592001014", Price: "101.250000", Consideration: "151875.00", Counterparty: "cp", Status: StatusSettled,
+		Enrichment: &Enrichment{SettlementAccount: "ACC-001", Custodian: "IRVTUS3N", PlaceOfSettlement: "DTCYUS33", SSIReference: "SSI-42", Commission: "12.50"}}
+}
+
+// ============================================================================================================================
+// instructionLedger - reference data, the settled buy and a netting set of two trades receiving 400 shares
+// ============================================================================================================================
+func instructionLedger() stateMap {
+	state := stateMap{}
+	state.put(counterpartyPrefix + "cp", Counterparty{ID: "cp", LegalName: "Counterparty Bank plc", LEI: "5493001KJTIIGC8Y1R12", Status: CounterpartyActive,
+		Settlement: SettlementDetails{Custodian: "Custodian Trust", Account: "CP-7788"}})
+	state.put(counterpartyPrefix + "nossi", Counterparty{ID: "nossi", LegalName: "No SSI Ltd", LEI: "529900T8BM49AURSDO55", Status: CounterpartyActive})
+	state.put(securityPrefix + "us4592001014", Security{ID: "us4592001014", IDType: "isin", InstrumentType: "equity", Currency: "USD", PriceMultiplier: "1", TickSize: "0.01"})
+	state.put(securityPrefix + "ibm", Security{ID: "ibm", IDType: "ticker", InstrumentType: "equity", Currency: "USD", PriceMultiplier: "1", TickSize: "0.0005"})
+	state.put(testTradeKey, testTrade())
+
+	first := testTrade()
+	first.ID = "trade_tx5_0"
+	first.TradeDate = "2017-02-28"
+	first.Security = "ibm"
+	first.Quantity = 600
+	first.Consideration = "60750.00"
+	first.NettingSet = testNettingSetKey
+	state.put(first.ID, first)
+
+	second := first
+	second.ID = "trade_tx6_0"
+	second.TradeDate = "2017-03-01"
+	second.Operation = "sell"
+	second.Quantity = 200
+	second.Consideration = "20249.50"
+	state.put(second.ID, second)
+
+	state.put(testNettingSetKey, NettingSet{ID: testNettingSetKey, Counterparty: "cp", Security: "ibm", ValueDate: "2017-03-03",
+		NetQuantity: 400, NetCash: "-40500.50", TradeKeys: []string{first.ID, second.ID}})
+	return state
+}
+
+// ============================================================================================================================
+// changeTrade - store a changed copy of a trade already on the ledger
+// ============================================================================================================================
+func (m stateMap) changeTrade(key string, change func(trade *Trade)) {
+	trade, _ := getTrade(m, key)
+	change(&trade)
+	m.put(key, trade)
+}
+
+// ============================================================================================================================
+// buildInstruction - instruction for the trade or netting set stored under key
+// ============================================================================================================================
+func buildInstruction(state stateMap, key string) (Instruction, error) {
+	if strings.HasPrefix(key, nettingSetPrefix) {
+		return nettingSetInstruction(state, key)
+	}
+	return tradeInstruction(state, key)
+}
+
+// ============================================================================================================================
+// checkGolden - compare rendered output with a file in testdata, go test -update rewrites the file instead
+// ============================================================================================================================
+func checkGolden(t *testing.T, name string, got string) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.WriteFile(path, []byte(got), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match the rendered message\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+func TestSettlementInstruction(t *testing.T) {
+	tests := []struct {
+		golden string											// file name without the extension, .mt541 or .mt543 and .xml
+		key string
+		change func(state stateMap)
+	}{
+		{"trade_receive", testTradeKey, nil},
+		{"trade_deliver", testTradeKey, func(state stateMap) {
+			state.changeTrade(testTradeKey, func(trade *Trade) { trade.Operation = "sell" })
+		}},
+		{"nettingset_receive", testNettingSetKey, nil},
+		{"nettingset_deliver", testNettingSetKey, func(state stateMap) {
+			set, _ := getNettingSet(state, testNettingSetKey)
+			set.NetQuantity = -400
+			set.NetCash = "40500.50"
+			state.put(testNettingSetKey, set)
+		}},
+	}
+	for _, test := range tests {
+		state := instructionLedger()
+		if test.change != nil {
+			test.change(state)
+		}
+		instruction, err := buildInstruction(state, test.key)
+		if err != nil {
+			t.Errorf("%s: %v", test.golden, err)
+			continue
+		}
+
+		extension := ".mt541"
+		if !instruction.Receive {
+			extension = ".mt543"
+		}
+		checkGolden(t, test.golden + extension, renderMT54x(instruction))
+
+		message, err := renderSese023(instruction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, test.golden + ".xml", message)
+	}
+}
+
+func TestSettlementInstructionRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		key string
+		change func(state stateMap)
+		code string
+	}{
+		{"unsettled trade", testTradeKey, func(state stateMap) {
+			state.changeTrade(testTradeKey, func(trade *Trade) { trade.Status = StatusEnriched })
+		}, ErrRejected},
+		{"netted trade", "trade_tx5_0", nil, ErrRejected},
+		{"counterparty without settlement details", testTradeKey, func(state stateMap) {
+			state.changeTrade(testTradeKey, func(trade *Trade) { trade.Counterparty = "nossi" })
+		}, ErrRejected},
+		{"unknown trade", "trade_tx7_0", nil, ErrNotFound},
+		{"set across two accounts", testNettingSetKey, func(state stateMap) {
+			state.changeTrade("trade_tx6_0", func(trade *Trade) { trade.Enrichment.SettlementAccount = "ACC-002" })
+		}, ErrRejected},
+		{"set with a missing trade", testNettingSetKey, func(state stateMap) {
+			delete(state, "trade_tx6_0")
+		}, ErrNotFound},
+	}
+	for _, test := range tests {
+		state := instructionLedger()
+		if test.change != nil {
+			test.change(state)
+		}
+		_, err := buildInstruction(state, test.key)
+		if errorCode(err) != test.code {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.code)
+		}
+	}
+}
+
+func TestNettingSetDirection(t *testing.T) {
+	tests := []struct {
+		quantity int
+		cash string
+		receive bool
+		ok bool
+	}{
+		{400, "-40500.50", true, true},							// receive against payment
+		{-400, "40500.50", false, true},						// deliver against payment
+		{400, "40500.50", false, false},						// receive and be paid
+		{-400, "-40500.50", false, false},						// deliver and pay
+		{-400, "0.00", false, false},							// deliver with no cash
+	}
+	for _, test := range tests {
+		receive, err := nettingSetDirection("ns1", NettingSet{NetQuantity: test.quantity, NetCash: test.cash})
+		if test.ok && (err != nil || receive != test.receive) {
+			t.Errorf("%d against %s: got %v, %v, want %v", test.quantity, test.cash, receive, err, test.receive)
+		}
+		if !test.ok && errorCode(err) != ErrRejected {
+			t.Errorf("%d against %s: got %v, want %s", test.quantity, test.cash, err, ErrRejected)
+		}
+	}
+}
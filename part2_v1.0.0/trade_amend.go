@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var tradeVersionPrefix = "_tradeversion_"		//prefix for the key/value that stores a superseded version of a trade
+var changesArg = 3								//index of the JSON changes argument of mark_revised, amendment errors point at it
+
+// ============================================================================================================================
+// amendTrade - keep the current version of a trade, apply the changed fields and bump the version number
+//              changes maps json field names to new values, e.g. {"price": "10.5", "quantity": "200"}
+// ============================================================================================================================
+func amendTrade(stub *shim.ChaincodeStub, key string, trade *Trade, changes map[string]string) error {
+	if len(changes) == 0 {
+		return argError(ErrInvalidArgs, changesArg, "An amendment must change at least one field")
+	}
+
+	previous := *trade
+	previous.Version = tradeVersion(previous)
+
+	var fields []string
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)												// map order is random, keep every peer's result identical
+
+	for _, field := range fields {
+		err := setTradeField(trade, field, changes[field])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := resolveValueDate(stub, trade, changesArg)
+	if err != nil {
+		return err
+	}
+
+	err = validateTrade(*trade)
+	if err != nil {
+		return err
+	}
+
+	err = checkReferenceData(stub, *trade)
+	if err != nil {
+		return err
+	}
+
+	err = priceTrade(stub, trade)
+	if err != nil {
+		return err
+	}
+
+	err = putTrade(stub, tradeVersionKey(key, previous.Version), previous)			// keep the superseded version
+	if err != nil {
+		return err
+	}
+
+	trade.Version = previous.Version + 1
+	return nil
+}
+
+// ============================================================================================================================
+// setTradeField - change one amendable field of a trade, values are lowercased like on creation
+// ============================================================================================================================
+func setTradeField(trade *Trade, field string, value string) error {
+	value = strings.ToLower(value)
+
+	switch strings.ToLower(field) {
+	case "tradedate":
+		trade.TradeDate = value
+	case "valuedate":
+		trade.ValueDate = value
+	case "operation":
+		trade.Operation = value
+	case "quantity":
+		quantity, err := strconv.Atoi(value)
+		if err != nil {
+			return argError(ErrInvalidArgs, changesArg, "quantity must be a numeric string")
+		}
+		trade.Quantity = quantity
+	case "security":
+		trade.Security = value
+	case "price":
+		trade.Price = value
+	case "counterparty":
+		trade.Counterparty = value
+	default:
+		return argError(ErrInvalidArgs, changesArg, "Field " + field + " cannot be amended")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// validateTrade - check the economic fields of a trade before it is stored
+// ============================================================================================================================
+func validateTrade(trade Trade) error {
+	tradeDate, err := parseDate("tradedate", trade.TradeDate)
+	if err != nil {
+		return err
+	}
+	valueDate, err := parseDate("valuedate", trade.ValueDate)
+	if err != nil {
+		return err
+	}
+	if valueDate.Before(tradeDate) {
+		return newError(ErrInvalidArgs, "valuedate must be on or after tradedate")
+	}
+	if trade.Operation != "buy" && trade.Operation != "sell" {
+		return newError(ErrInvalidArgs, "operation must be buy or sell")
+	}
+	if trade.Quantity <= 0 {
+		return newError(ErrInvalidArgs, "quantity must be greater than 0")
+	}
+	if len(trade.Security) == 0 {
+		return newError(ErrInvalidArgs, "security must be a non-empty string")
+	}
+	if len(trade.Price) == 0 {
+		return newError(ErrInvalidArgs, "price must be a non-empty string")
+	}
+	if len(trade.Counterparty) == 0 {
+		return newError(ErrInvalidArgs, "counterparty must be a non-empty string")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkReferenceData - check a trade against the ledger's reference data, reversals offset a booked trade and are exempt
+// ============================================================================================================================
+func checkReferenceData(stub *shim.ChaincodeStub, trade Trade) error {
+	if trade.ReversalOf != "" {
+		return nil
+	}
+
+	err := checkCounterparty(stub, trade.Counterparty)
+	if err != nil {
+		return err
+	}
+	return checkSecurity(stub, trade.Security, trade.Price)
+}
+
+// ============================================================================================================================
+// priceTrade - normalise the price to the configured scale and compute gross consideration,
+//              quantity x price x the security's price multiplier, rounded half to even to the configured amount scale
+// ============================================================================================================================
+func priceTrade(stub *shim.ChaincodeStub, trade *Trade) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	multiplier, err := priceMultiplier(stub, trade.Security)
+	if err != nil {
+		return err
+	}
+	return priceTradeWith(trade, config, multiplier)
+}
+
+// ============================================================================================================================
+// priceTradeWith - priceTrade once the settings and the security's price multiplier have been read
+// ============================================================================================================================
+func priceTradeWith(trade *Trade, config Config, multiplier Decimal) error {
+	price, err := ParseDecimal(trade.Price, config.PriceScale)
+	if err != nil {
+		return newError(ErrInvalidArgs, "price " + err.Error())
+	}
+	if price.Sign() <= 0 {
+		return newError(ErrInvalidArgs, "price must be greater than 0")
+	}
+
+	trade.Price = price.String()
+	trade.Consideration = price.MulInt(int64(trade.Quantity)).Mul(multiplier).Rescale(config.AmountScale).String()
+	return nil
+}
+
+// ============================================================================================================================
+// backfillTrade - fill in the id, trader and consideration that trades stored before they existed lack
+//                 the booking trader is the user of the first audit entry, the caller stores the trade
+// ============================================================================================================================
+func backfillTrade(stub *shim.ChaincodeStub, key string, trade *Trade) error {
+	if trade.ID == "" {
+		trade.ID = key
+	}
+	if trade.Trader == "" {
+		history, err := getHistory(stub, key)
+		if err != nil {
+			return err
+		}
+		if len(history) > 0 && history[0].From == "" {
+			trade.Trader = history[0].User							// the creation entry
+		} else if len(history) > 0 {
+			trade.Trader = history[0].PreviousUser					// booked before the audit trail
+		}
+		if trade.Trader == "" {
+			trade.Trader = trade.User
+		}
+	}
+	if trade.Consideration == "" {
+		return priceTrade(stub, trade)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// tradeVersion - version number of a trade, trades stored before versioning are version 1
+// ============================================================================================================================
+func tradeVersion(trade Trade) int {
+	if trade.Version == 0 {
+		return 1
+	}
+	return trade.Version
+}
+
+// ============================================================================================================================
+// tradeVersionKey - key a superseded version of a trade is stored under
+// ============================================================================================================================
+func tradeVersionKey(key string, version int) string {
+	return tradeVersionPrefix + key + "_" + strconv.Itoa(version)
+}
+
+// ============================================================================================================================
+// trade_version - return a trade as it was at a given version, the current version included
+// ============================================================================================================================
+func (t *SimpleChaincode) trade_version(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, key and version of the trade")
+	}
+
+	fmt.Println("- start trade_version")
+
+	version, err := strconv.Atoi(args[1])
+	if err != nil || version < 1 {
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a numeric string greater than 0")
+	}
+
+	trade, err := getTrade(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if version == tradeVersion(trade) {
+		return json.Marshal(trade)
+	}
+	if version > tradeVersion(trade) {
+		return nil, newError(ErrNotFound, "Trade " + args[0] + " has no version " + args[1])
+	}
+
+	versionAsBytes, err := stub.GetState(tradeVersionKey(args[0], version))
+	if err != nil || len(versionAsBytes) == 0 {
+		return nil, newError(ErrInternal, "Failed to get version " + args[1] + " of trade " + args[0])
+	}
+	previous, err := decodeTrade(versionAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end trade_version")
+	return json.Marshal(previous)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var archivedTradePrefix = "_archivedtrade_"			//prefix for the key/value that stores an archived trade
+var archivedTradeIndexStr = "_archivedtradeindex"		//name for the key/value that will store a list of all archived trade keys
+
+// ArchivedTrade is a trade removed from the active namespace together with everything kept about it, one record in
+// place of the trade, its history and a record per superseded version. The history and versions are kept because an
+// archived trade is still audited, read_archived answers what trade_history and trade_version did while it was active
+type ArchivedTrade struct {
+	Trade Trade `json:"trade"`
+	History []AuditEntry `json:"history"`
+	Versions []Trade `json:"versions"`					// superseded versions, oldest first
+	ArchivedAt string `json:"archivedat"`				// transaction timestamp, RFC 3339 UTC
+	Reason string `json:"reason"`
+}
+
+// ClearReport is returned by clear_all_trades and archive_trades
+type ClearReport struct {
+	Mode string `json:"mode"`							// delete or archive
+	Cleared int `json:"cleared"`
+	Keys []string `json:"keys"`
+}
+
+// ============================================================================================================================
+// removeTrade - delete a trade with its history and superseded versions, archiving them first if asked to
+//               the caller updates the trade and archive indexes, positions the trade settled into are left as they are
+// ============================================================================================================================
+func removeTrade(stub *shim.ChaincodeStub, key string, archive bool, reason string) error {
+	trade, err := getTrade(stub, key)
+	if err != nil {
+		return err
+	}
+
+	history, err := getHistory(stub, key)
+	if err != nil {
+		return err
+	}
+
+	versions := []Trade{}
+	for v := 1; v < tradeVersion(trade); v++ {
+		versionAsBytes, err := stub.GetState(tradeVersionKey(key, v))
+		if err != nil {
+			return newError(ErrInternal, "Failed to get version " + strconv.Itoa(v) + " of trade " + key)
+		}
+		if len(versionAsBytes) > 0 {
+			version, err := decodeTrade(versionAsBytes)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, version)
+		}
+	}
+
+	if archive {
+		txTime, err := txTimestamp(stub)
+		if err != nil {
+			return err
+		}
+
+		archived := ArchivedTrade{}
+		archived.Trade = trade
+		archived.History = history
+		archived.Versions = versions
+		archived.ArchivedAt = txTime
+		archived.Reason = reason
+
+		jsonAsBytes, _ := json.Marshal(archived)
+		err = stub.PutState(archivedTradePrefix + key, jsonAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tradeStatus(trade) != StatusSettled {							// a settled match stays matched on the other side
+		err = releaseMatch(stub, key, &trade)							// the other side's version is alleged again
+		if err != nil {
+			return err
+		}
+	}
+
+	for v := 1; v < tradeVersion(trade); v++ {
+		err = stub.DelState(tradeVersionKey(key, v))
+		if err != nil {
+			return err
+		}
+	}
+	err = stub.DelState(tradeHistoryPrefix + key)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// ============================================================================================================================
+// closedAt - when a settled or cancelled trade reached its final state, trades without a history fall back to their value date
+// ============================================================================================================================
+func closedAt(trade Trade, history []AuditEntry) (time.Time, error) {
+	if len(history) > 0 {
+		return time.Parse(time.RFC3339Nano, history[len(history)-1].TxTime)
+	}
+	return time.Parse(dateLayout, trade.ValueDate)
+}
+
+// ============================================================================================================================
+// archive_trades - move settled and cancelled trades closed more than the retention period ago into the archive
+//                  the number of days defaults to the retentiondays setting, age is measured against the transaction time
+//                  trades settled in a netting set stay active, settlement_instruction reads them to instruct the set
+// ============================================================================================================================
+func (t *SimpleChaincode) archive_trades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an optional number of days")
+	}
+
+	fmt.Println("- start archive_trades")
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	days := config.RetentionDays
+	if len(args) == 1 {
+		days, err = strconv.Atoi(args[0])
+		if err != nil || days < 1 {
+			return nil, argError(ErrInvalidArgs, 0, "1st argument must be a number of days greater than 0")
+		}
+	}
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, newError(ErrInternal, "Failed to get transaction timestamp")
+	}
+	cutoff := time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().AddDate(0, 0, -days)
+
+	report := ClearReport{}
+	report.Mode = "archive"
+	report.Keys = []string{}
+	for _, name := range []string{tradeIndexStr, cancelledTradeIndexStr} {		// settled trades are active, cancelled ones are not
+		index, err := getIndex(stub, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []string
+		for _, key := range index {
+			trade, err := getTrade(stub, key)
+			if err != nil {
+				return nil, err
+			}
+			status := tradeStatus(trade)
+			if status != StatusSettled && status != StatusCancelled {
+				kept = append(kept, key)
+				continue
+			}
+			if trade.NettingSet != "" {								// its netting set reads it to build the instruction
+				kept = append(kept, key)
+				continue
+			}
+
+			history, err := getHistory(stub, key)
+			if err != nil {
+				return nil, err
+			}
+			closed, err := closedAt(trade, history)
+			if err != nil || !closed.Before(cutoff) {					// keep anything whose age cannot be told
+				kept = append(kept, key)
+				continue
+			}
+
+			err = removeTrade(stub, key, true, "retention of " + strconv.Itoa(days) + " days")
+			if err != nil {
+				return nil, err
+			}
+			report.Keys = append(report.Keys, key)
+		}
+
+		err = putIndex(stub, name, kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Cleared = len(report.Keys)
+
+	if report.Cleared > 0 {
+		archivedIndex, err := getIndex(stub, archivedTradeIndexStr)
+		if err != nil {
+			return nil, err
+		}
+		err = putIndex(stub, archivedTradeIndexStr, append(archivedIndex, report.Keys...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("- end archive_trades")
+	return json.Marshal(report)
+}
+
+// ============================================================================================================================
+// read_archived - return an archived trade with its history and superseded versions
+// ============================================================================================================================
+func (t *SimpleChaincode) read_archived(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting key of the archived trade")
+	}
+
+	key := strings.ToLower(args[0])
+	archivedAsBytes, err := stub.GetState(archivedTradePrefix + key)
+	if err != nil {
+		return nil, newError(ErrInternal, "Failed to get value for key " + archivedTradePrefix + key)
+	}
+	if len(archivedAsBytes) == 0 {
+		return nil, newError(ErrNotFound, "No archived trade found for key " + key)
+	}
+
+	archived, _, err := decodeArchivedTrade(archivedAsBytes)				// any schema version, see trade_schema.go
+	if err != nil {
+		return nil, newError(ErrInternal, "Archived trade " + key + ": " + err.Error())
+	}
+	return json.Marshal(archived)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var cancelledTradeIndexStr = "_cancelledtradeindex"		//name for the key/value that will store a list of all cancelled trades
+
+// ============================================================================================================================
+// cancel_trade - cancel a single trade, moving it out of the active trade index
+//                settled trades can only be cancelled with "reverse", which books a reversing trade that an approver settles
+// ============================================================================================================================
+func (t *SimpleChaincode) cancel_trade(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//  0      1         2            3
+	// key, "user", "reason", "reverse"
+	if len(args) < 3 || len(args) > 4 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 3, key, user and reason, plus an optional \"reverse\"")
+	}
+
+	fmt.Println("- start cancel_trade")
+
+	if len(args[0]) <= 0 {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return nil, argError(ErrInvalidArgs, 1, "2nd argument must be a non-empty string")
+	}
+	if len(strings.TrimSpace(args[2])) <= 0 {
+		return nil, argError(ErrInvalidArgs, 2, "3rd argument must be a non-empty reason for the cancellation")
+	}
+
+	key := strings.ToLower(args[0])
+	user := strings.ToLower(args[1])
+	reason := args[2]
+	reverse := len(args) > 3 && strings.ToLower(args[3]) == "reverse"
+
+	err := checkActor(stub, user)
+	if err != nil {
+		return nil, err
+	}
+
+	trade, err := getTrade(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTradeOwner(stub, user, key, trade, []string{RoleMiddleOffice})		// middle office may cancel any trade
+	if err != nil {
+		return nil, err
+	}
+
+	from := tradeStatus(trade)
+	reversalKey := ""
+	if from == StatusSettled {
+		if !reverse {
+			return nil, newError(ErrIllegalTransition, "Trade " + key + " is settled, cancel it with \"reverse\" to book a reversing trade")
+		}
+
+		reversalKey, err = addReversal(stub, key, trade, user)
+		if err != nil {
+			return nil, err
+		}
+		trade.ReversedBy = reversalKey
+	}
+
+	err = moveTrade(stub, key, &trade, StatusCancelled, user, reason)
+	if err != nil {
+		return nil, err
+	}
+	err = releaseMatch(stub, key, &trade)								// the other side's version is alleged again
+	if err != nil {
+		return nil, err
+	}
+	trade.User = user
+
+	err = putTrade(stub, key, trade)
+	if err != nil {
+		return nil, err
+	}
+
+	tradeIndex, err := getTradeIndex(stub)								// move the trade out of the active index
+	if err != nil {
+		return nil, err
+	}
+	err = putIndex(stub, tradeIndexStr, removeKey(tradeIndex, key))
+	if err != nil {
+		return nil, err
+	}
+
+	cancelledIndex, err := getIndex(stub, cancelledTradeIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	err = putIndex(stub, cancelledTradeIndexStr, append(cancelledIndex, key))
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitTradeEvent(stub, EventTradeCancelled, key, from, StatusCancelled, user)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end cancel_trade")
+	return []byte(reversalKey), nil									// id of the reversing trade, if one was booked
+}
+
+// ============================================================================================================================
+// addReversal - book a trade with the opposite operation that offsets a settled trade, enriched by the user cancelling it
+//               it settles and moves the original trader's position only once approve_settlement has a second pair of eyes on it
+// ============================================================================================================================
+func addReversal(stub *shim.ChaincodeStub, key string, trade Trade, user string) (string, error) {
+	operation, err := oppositeOperation(trade.Operation)
+	if err != nil {
+		return "", err
+	}
+
+	reversal := Trade{}
+	reversal.TradeDate = trade.TradeDate
+	reversal.ValueDate = trade.ValueDate
+	reversal.Operation = operation
+	reversal.Quantity = trade.Quantity
+	reversal.Security = trade.Security
+	reversal.Price = trade.Price
+	reversal.Counterparty = trade.Counterparty
+	reversal.Party = trade.Party
+	reversal.User = user
+	reversal.Trader = tradeOwner(trade)									// offsets the original trader's position
+	reversal.Timestamp = trade.Timestamp
+	reversal.ReversalOf = key
+	reversal.Enrichment = trade.Enrichment							// settles through the same accounts
+
+	reversalKey, err := addTrade(stub, reversal, 0)
+	if err != nil {
+		return "", err
+	}
+
+	reversal, err = getTrade(stub, reversalKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = moveTrade(stub, reversalKey, &reversal, StatusEnriched, user, "reversal of " + key)
+	if err != nil {
+		return "", err
+	}
+	reversal.EnrichedBy = user											// the canceller cannot approve it, see checkFourEyes
+
+	return reversalKey, putTrade(stub, reversalKey, reversal)
+}
+
+// ============================================================================================================================
+// oppositeOperation - sell for buy and buy for sell
+// ============================================================================================================================
+func oppositeOperation(operation string) (string, error) {
+	switch operation {
+	case "buy":
+		return "sell", nil
+	case "sell":
+		return "buy", nil
+	}
+	return "", newError(ErrInternal, "Operation " + operation + " has no opposite, expecting buy or sell")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Enrichment holds the settlement details back office adds to a trade before it settles
+type Enrichment struct {
+	SettlementAccount string `json:"settlementaccount"`
+	Custodian string `json:"custodian"`						// custodian or central securities depository
+	PlaceOfSettlement string `json:"placeofsettlement"`
+	SSIReference string `json:"ssireference"`				// standing settlement instruction reference
+	Commission string `json:"commission,omitempty"`
+}
+
+// ============================================================================================================================
+// parseEnrichment - un stringify an enrichment argument and check it is complete
+// ============================================================================================================================
+func parseEnrichment(arg string) (*Enrichment, error) {
+	var enrichment Enrichment
+
+	err := json.Unmarshal([]byte(arg), &enrichment)
+	if err != nil {
+		return nil, newError(ErrInvalidArgs, "Enrichment must be a JSON object with settlementaccount, custodian, placeofsettlement and ssireference")
+	}
+
+	enrichment.SettlementAccount = strings.TrimSpace(enrichment.SettlementAccount)
+	enrichment.Custodian = strings.TrimSpace(enrichment.Custodian)
+	enrichment.PlaceOfSettlement = strings.TrimSpace(enrichment.PlaceOfSettlement)
+	enrichment.SSIReference = strings.TrimSpace(enrichment.SSIReference)
+	enrichment.Commission = strings.TrimSpace(enrichment.Commission)
+
+	err = validateEnrichment(&enrichment)
+	if err != nil {
+		return nil, err
+	}
+	return &enrichment, nil
+}
+
+// ============================================================================================================================
+// validateEnrichment - every mandatory enrichment field must be present before a trade may settle
+// ============================================================================================================================
+func validateEnrichment(enrichment *Enrichment) error {
+	if enrichment == nil {
+		return newError(ErrIllegalTransition, "Trade has not been enriched")
+	}
+
+	var missing []string
+	if len(enrichment.SettlementAccount) == 0 {
+		missing = append(missing, "settlementaccount")
+	}
+	if len(enrichment.Custodian) == 0 {
+		missing = append(missing, "custodian")
+	}
+	if len(enrichment.PlaceOfSettlement) == 0 {
+		missing = append(missing, "placeofsettlement")
+	}
+	if len(enrichment.SSIReference) == 0 {
+		missing = append(missing, "ssireference")
+	}
+
+	if len(missing) > 0 {
+		return newError(ErrInvalidArgs, "Enrichment is missing mandatory fields: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var tradeHistoryPrefix = "_tradehistory_"		//prefix for the key/value that stores the audit trail of a trade
+
+type AuditEntry struct {
+	Seq int `json:"seq"`							// position in the trade's history, starting at 1
+	From string `json:"from"`						// state before the transition, empty for creation
+	To string `json:"to"`							// state after the transition
+	Version int `json:"version"`					// trade version after the transition
+	PreviousUser string `json:"previoususer"`	// trade user before the transition
+	User string `json:"user"`						// acting user
+	Reason string `json:"reason"`
+	TxID string `json:"txid"`
+	TxTime string `json:"txtime"`				// transaction timestamp, RFC 3339 UTC
+}
+
+// ============================================================================================================================
+// moveTrade - transition a trade and append the change to its audit trail, the caller still has to store the trade
+//             settling books the trade into its owner's position in the same transaction
+// ============================================================================================================================
+func moveTrade(stub *shim.ChaincodeStub, key string, trade *Trade, to string, actor string, reason string) error {
+	from := tradeStatus(*trade)
+
+	err := transitionTrade(trade, to)
+	if err != nil {
+		return err
+	}
+
+	if to == StatusSettled {
+		err = backfillTrade(stub, key, trade)							// trades booked before pricing settle at their price
+		if err != nil {
+			return err
+		}
+		err = applyPosition(stub, *trade)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := AuditEntry{}
+	entry.From = from
+	entry.To = to
+	entry.Version = tradeVersion(*trade)
+	entry.PreviousUser = trade.User
+	entry.User = actor
+	entry.Reason = reason
+	return appendHistory(stub, key, entry)
+}
+
+// ============================================================================================================================
+// appendHistory - stamp an audit entry with the transaction and add it to the end of the trade's history
+// ============================================================================================================================
+func appendHistory(stub *shim.ChaincodeStub, key string, entry AuditEntry) error {
+	history, err := getHistory(stub, key)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	entry.Seq = len(history) + 1
+	entry.TxID = stub.GetTxID()
+	entry.TxTime = txTime
+	history = append(history, entry)
+
+	jsonAsBytes, _ := json.Marshal(history)
+	return stub.PutState(tradeHistoryPrefix + key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// getHistory - read the ordered audit trail of a trade, empty if nothing has been recorded yet
+// ============================================================================================================================
+func getHistory(stub *shim.ChaincodeStub, key string) ([]AuditEntry, error) {
+	var history []AuditEntry
+
+	historyAsBytes, err := stub.GetState(tradeHistoryPrefix + key)
+	if err != nil {
+		return nil, newError(ErrInternal, "Failed to get history for key " + key)
+	}
+	if len(historyAsBytes) > 0 {
+		json.Unmarshal(historyAsBytes, &history)						// un stringify it aka JSON.parse()
+	}
+	return history, nil
+}
+
+// ============================================================================================================================
+// trade_history - return the full ordered audit trail for a trade key
+// ============================================================================================================================
+func (t *SimpleChaincode) trade_history(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting key of the trade to query")
+	}
+
+	fmt.Println("- start trade_history")
+
+	_, err := getTrade(stub, args[0])								// make sure the trade exists
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := getHistory(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = []AuditEntry{}									// trades created before the audit trail have no entries
+	}
+
+	fmt.Println("- end trade_history")
+	return json.Marshal(history)
+}
+
+// ============================================================================================================================
+// txTimestamp - timestamp of the current transaction, identical on every peer unlike the local clock
+// ============================================================================================================================
+func txTimestamp(stub *shim.ChaincodeStub) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", newError(ErrInternal, "Failed to get transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var maxImportRows = 1000						//upper bound on rows one import_trades call may carry
+
+var importColumns = []string{"tradedate", "valuedate", "operation", "quantity", "security", "price", "counterparty", "timestamp"}
+var requiredImportColumns = []string{"tradedate", "valuedate", "operation", "quantity", "security", "price", "counterparty"}
+
+// ImportResult is the outcome of one CSV row, Row counts data rows from 1, the header is not counted
+type ImportResult struct {
+	Row int `json:"row"`
+	Key string `json:"key,omitempty"`						// key of the created trade
+	Error *ChaincodeError `json:"error,omitempty"`			// why the row was rejected, with the code a failed call would return
+}
+
+// ImportReport is returned by import_trades
+type ImportReport struct {
+	Created int `json:"created"`
+	Rejected int `json:"rejected"`
+	Rows []ImportResult `json:"rows"`
+}
+
+// ============================================================================================================================
+// importHeader - column positions from the header row, every required column must be present and none unknown
+// ============================================================================================================================
+func importHeader(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, column := range importColumns {
+			if name == column {
+				known = true
+			}
+		}
+		if !known {
+			return nil, newError(ErrInvalidArgs, "Unknown CSV column " + name + ", expecting: " + strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, newError(ErrInvalidArgs, "CSV column " + name + " appears more than once")
+		}
+		columns[name] = i
+	}
+
+	var missing []string
+	for _, column := range requiredImportColumns {
+		if _, ok := columns[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, newError(ErrInvalidArgs, "CSV header is missing columns: " + strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// ============================================================================================================================
+// importRow - a trade from one CSV row, values are lowercased like create_and_submit_trade does
+// ============================================================================================================================
+func importRow(columns map[string]int, record []string, user string) (Trade, error) {
+	trade := Trade{}
+
+	value := func(name string) string {
+		i, ok := columns[name]
+		if !ok {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(record[i]))
+	}
+
+	quantity, err := strconv.Atoi(value("quantity"))
+	if err != nil {
+		return trade, newError(ErrInvalidArgs, "quantity must be a numeric string")
+	}
+
+	trade.TradeDate = value("tradedate")
+	trade.ValueDate = value("valuedate")
+	trade.Operation = value("operation")
+	trade.Quantity = quantity
+	trade.Security = value("security")
+	trade.Price = value("price")
+	trade.Counterparty = value("counterparty")
+	trade.User = user
+	trade.Timestamp = value("timestamp")
+	return trade, nil
+}
+
+// ============================================================================================================================
+// import_trades - create a submitted trade for every valid row of a CSV payload with a header row
+//                 rejected rows do not stop the import, ledger failures do, the trade index is written once for all created trades
+// ============================================================================================================================
+func (t *SimpleChaincode) import_trades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//   0       1
+	// "user", "tradedate,valuedate,operation,...\n2016-10-14,auto,buy,..."
+	if len(args) != 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2, user and a CSV payload")
+	}
+
+	fmt.Println("- start import_trades")
+
+	user := strings.ToLower(args[0])
+	err := checkActor(stub, user)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(strings.NewReader(args[1]))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, argError(ErrInvalidArgs, 1, "CSV payload must start with a header row")
+	}
+	columns, err := importHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ImportReport{}
+	report.Rows = []ImportResult{}
+	var keys []string
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if row > maxImportRows {
+			return nil, argError(ErrInvalidArgs, 1, "CSV payload has more than " + strconv.Itoa(maxImportRows) + " rows, split it into smaller imports")
+		}
+
+		result := ImportResult{}
+		result.Row = row
+		if parseErr, ok := err.(*csv.ParseError); ok && parseErr.Err == csv.ErrFieldCount {
+			result.Error = asChaincodeError(argError(ErrInvalidArgs, 1, "row has " + strconv.Itoa(len(record)) + " fields, the header has " + strconv.Itoa(len(header))))
+		} else if err != nil {
+			return nil, argError(ErrInvalidArgs, 1, "CSV payload could not be read at row " + strconv.Itoa(row) + ": " + err.Error())
+		} else {
+			trade, err := importRow(columns, record, user)
+			if err == nil {
+				result.Key, err = storeTrade(stub, trade, row - 1)		// rows keep their position in the trade id
+			}
+			if err != nil && errorCode(err) == ErrInternal {
+				return nil, err											// a failed ledger write is not a rejected row
+			}
+			if err != nil {
+				result.Error = asChaincodeError(err)
+			}
+		}
+
+		if result.Error != nil {
+			report.Rejected++
+		} else {
+			report.Created++
+			keys = append(keys, result.Key)
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if len(keys) > 0 {
+		tradeIndex, err := getTradeIndex(stub)
+		if err != nil {
+			return nil, err
+		}
+		err = putIndex(stub, tradeIndexStr, append(tradeIndex, keys...))
+		if err != nil {
+			return nil, err
+		}
+
+		err = emitBatchEvent(stub, EventTradesImported, BatchEvent{Keys: keys, NewState: StatusSubmitted, Actor: user})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("- end import_trades")
+	return json.Marshal(report)
+}
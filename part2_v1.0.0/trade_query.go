@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TradeFilter selects trades in listings, empty fields match everything
+type TradeFilter struct {
+	User string `json:"user"`
+	Security string `json:"security"`
+	Counterparty string `json:"counterparty"`
+	Operation string `json:"operation"`
+	Status string `json:"status"`
+	From string `json:"from"`							// earliest trade date, inclusive
+	To string `json:"to"`								// latest trade date, inclusive
+}
+
+// TradePage is one page of a trade listing, Bookmark is empty on the last page
+type TradePage struct {
+	Records []Trade `json:"records"`
+	Bookmark string `json:"bookmark"`						// pass back to get the next page
+}
+
+var maxPageSize = 500										// upper bound on records returned by one page
+
+// ============================================================================================================================
+// parseTradeFilter - un stringify a filter argument, values are lowercased like the trades they are compared to
+// ============================================================================================================================
+func parseTradeFilter(arg string) (TradeFilter, error) {
+	var filter TradeFilter
+
+	if len(arg) == 0 {
+		return filter, nil
+	}
+
+	err := json.Unmarshal([]byte(arg), &filter)
+	if err != nil {
+		return filter, newError(ErrInvalidArgs, "Filter must be a JSON object, e.g. {\"user\": \"bob\", \"from\": \"2016-01-01\"}")
+	}
+
+	filter.User = strings.ToLower(filter.User)
+	filter.Security = strings.ToLower(filter.Security)
+	filter.Counterparty = strings.ToLower(filter.Counterparty)
+	filter.Operation = strings.ToLower(filter.Operation)
+	filter.Status = strings.ToLower(filter.Status)
+	filter.From = strings.ToLower(filter.From)
+	filter.To = strings.ToLower(filter.To)
+	return filter, nil
+}
+
+// ============================================================================================================================
+// matches - true if the trade passes every field set on the filter
+// ============================================================================================================================
+func (f TradeFilter) matches(trade Trade) bool {
+	if f.User != "" && trade.User != f.User {
+		return false
+	}
+	if f.Security != "" && trade.Security != f.Security {
+		return false
+	}
+	if f.Counterparty != "" && trade.Counterparty != f.Counterparty {
+		return false
+	}
+	if f.Operation != "" && trade.Operation != f.Operation {
+		return false
+	}
+	if f.Status != "" && tradeStatus(trade) != f.Status {
+		return false
+	}
+	if f.From != "" && trade.TradeDate < f.From {					// ISO dates compare correctly as strings
+		return false
+	}
+	if f.To != "" && trade.TradeDate > f.To {
+		return false
+	}
+	return true
+}
+
+// ============================================================================================================================
+// getTradeIndex - read the list of all active trade keys
+// ============================================================================================================================
+func getTradeIndex(stub *shim.ChaincodeStub) ([]string, error) {
+	return getIndex(stub, tradeIndexStr)
+}
+
+// ============================================================================================================================
+// listingIndex - index a listing walks, cancelled trades are kept out of the active index
+// ============================================================================================================================
+func listingIndex(stub *shim.ChaincodeStub, filter TradeFilter) ([]string, error) {
+	if filter.Status == StatusCancelled {
+		return getIndex(stub, cancelledTradeIndexStr)
+	}
+	return getTradeIndex(stub)
+}
+
+// ============================================================================================================================
+// getIndex - read a list of keys stored as a JSON array
+// ============================================================================================================================
+func getIndex(stub *shim.ChaincodeStub, name string) ([]string, error) {
+	var index []string
+
+	indexAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, newError(ErrInternal, "Failed to get value for key " + name)
+	}
+	json.Unmarshal(indexAsBytes, &index)								// un stringify it aka JSON.parse()
+	return index, nil
+}
+
+// ============================================================================================================================
+// putIndex - write a list of keys as a JSON array
+// ============================================================================================================================
+func putIndex(stub *shim.ChaincodeStub, name string, index []string) error {
+	jsonAsBytes, _ := json.Marshal(index)
+	return stub.PutState(name, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// removeKey - index without the given key
+// ============================================================================================================================
+func removeKey(index []string, key string) []string {
+	for i, val := range index {
+		if val == key {
+			return append(index[:i], index[i+1:]...)
+		}
+	}
+	return index
+}
+
+// ============================================================================================================================
+// list_trades - return every indexed trade that matches an optional JSON filter, in one round trip
+// ============================================================================================================================
+func (t *SimpleChaincode) list_trades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting an optional JSON filter")
+	}
+
+	fmt.Println("- start list_trades")
+
+	filter := TradeFilter{}
+	if len(args) == 1 {
+		var err error
+		filter, err = parseTradeFilter(args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tradeIndex, err := listingIndex(stub, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	trades := []Trade{}
+	for _, key := range tradeIndex {
+		trade, err := getTrade(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(trade) {
+			trades = append(trades, trade)
+		}
+	}
+
+	fmt.Println("- end list_trades")
+	return json.Marshal(trades)
+}
+
+// ============================================================================================================================
+// list_trades_page - return one page of trades matching a JSON filter, empty for all, plus a bookmark for the next page
+// ============================================================================================================================
+func (t *SimpleChaincode) list_trades_page(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//     0          1           2
+	// filter, "page size", "bookmark"
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 2 or 3, filter, page size and an optional bookmark")
+	}
+
+	fmt.Println("- start list_trades_page")
+
+	filter, err := parseTradeFilter(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := parsePageSize(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	tradeIndex, err := listingIndex(stub, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if len(args) == 3 {
+		start, err = decodeBookmark(args[2], tradeIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := TradePage{}
+	page.Records = []Trade{}
+	i := start
+	for ; i < len(tradeIndex) && len(page.Records) < pageSize; i++ {
+		trade, err := getTrade(stub, tradeIndex[i])
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(trade) {
+			page.Records = append(page.Records, trade)
+		}
+	}
+	if i < len(tradeIndex) {
+		page.Bookmark = encodeBookmark(i - 1, tradeIndex[i - 1])
+	}
+
+	fmt.Println("- end list_trades_page")
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// parsePageSize - page size argument, must be between 1 and maxPageSize
+// ============================================================================================================================
+func parsePageSize(arg string) (int, error) {
+	pageSize, err := strconv.Atoi(arg)
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		return 0, newError(ErrInvalidArgs, "Page size must be a numeric string between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	return pageSize, nil
+}
+
+// ============================================================================================================================
+// encodeBookmark - opaque bookmark for the last key a page looked at and its index position
+// ============================================================================================================================
+func encodeBookmark(position int, key string) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(position) + ":" + key))
+}
+
+// ============================================================================================================================
+// decodeBookmark - index position the next page starts at, right after the bookmarked key, an empty bookmark is the first page
+//                  keys removed from the index between pages shift positions, so the key is looked up rather than trusted
+//                  by position, and a bookmarked key that was removed itself resumes at its old position
+// ============================================================================================================================
+func decodeBookmark(bookmark string, index []string) (int, error) {
+	if len(bookmark) == 0 {
+		return 0, nil
+	}
+
+	raw, err := base64.URLEncoding.DecodeString(bookmark)
+	if err != nil {
+		return 0, newError(ErrInvalidArgs, "Invalid bookmark")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, newError(ErrInvalidArgs, "Invalid bookmark")
+	}
+	position, err := strconv.Atoi(parts[0])
+	if err != nil || position < 0 {
+		return 0, newError(ErrInvalidArgs, "Invalid bookmark")
+	}
+	key := parts[1]
+
+	if position < len(index) && index[position] == key {
+		return position + 1, nil
+	}
+	for i := len(index) - 1; i >= 0; i-- {						// keys are appended, so look from the end
+		if index[i] == key {
+			return i + 1, nil
+		}
+	}
+	if position > len(index) {
+		return len(index), nil
+	}
+	return position, nil
+}
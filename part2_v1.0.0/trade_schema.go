@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var tradeSchemaVersion = 2						//layout putTrade writes, records without a schema field are version 1
+
+// MigrationReport is returned by migrate_records
+type MigrationReport struct {
+	Checked int `json:"checked"`						// trades looked at by this batch, archived ones included
+	Migrated int `json:"migrated"`					// records rewritten, superseded versions included
+	Bookmark string `json:"bookmark,omitempty"`		// pass to the next batch, empty once every trade has been checked
+}
+
+// ============================================================================================================================
+// storedSchema - schema version of a stored trade, records written before versioning have none and are version 1
+// ============================================================================================================================
+func storedSchema(tradeAsBytes []byte) int {
+	var stored struct {
+		Schema int `json:"schema"`
+	}
+	json.Unmarshal(tradeAsBytes, &stored)
+	if stored.Schema == 0 {
+		return 1
+	}
+	return stored.Schema
+}
+
+// ============================================================================================================================
+// decodeTrade - un stringify a stored trade of any schema version into the current layout
+//               version 1 records were written by hand or through ,string tags, so quantity, settled and needsrevision
+//               may be numbers or quoted numbers, and part2 wrote the timestamp as a number
+// ============================================================================================================================
+func decodeTrade(tradeAsBytes []byte) (Trade, error) {
+	var trade Trade
+
+	schema := storedSchema(tradeAsBytes)
+	if schema > tradeSchemaVersion {
+		return trade, newError(ErrInternal, "Trade schema " + strconv.Itoa(schema) + " is newer than this chaincode understands")
+	}
+
+	if schema == 1 {
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(tradeAsBytes, &fields)
+		if err != nil {
+			return trade, newError(ErrInternal, "Stored trade is not a JSON object")
+		}
+		for _, name := range []string{"quantity", "settled", "needsrevision", "timestamp"} {
+			raw := bytes.TrimSpace(fields[name])
+			if len(raw) > 0 && raw[0] != '"' && string(raw) != "null" {
+				fields[name] = json.RawMessage(strconv.Quote(string(raw)))		// the current layout quotes all four
+			}
+		}
+		tradeAsBytes, _ = json.Marshal(fields)
+	}
+
+	err := json.Unmarshal(tradeAsBytes, &trade)
+	if err != nil {
+		return trade, newError(ErrInternal, "Stored trade does not match schema " + strconv.Itoa(schema) + ": " + err.Error())
+	}
+	trade.Schema = tradeSchemaVersion
+	return trade, nil
+}
+
+// ============================================================================================================================
+// decodeArchivedTrade - un stringify an archived trade, its trade and versions may be stored under any schema version
+//                       also returns whether any of them was older than the current layout
+// ============================================================================================================================
+func decodeArchivedTrade(archivedAsBytes []byte) (ArchivedTrade, bool, error) {
+	var archived ArchivedTrade
+	var stored struct {
+		Trade json.RawMessage `json:"trade"`
+		History []AuditEntry `json:"history"`
+		Versions []json.RawMessage `json:"versions"`
+		ArchivedAt string `json:"archivedat"`
+		Reason string `json:"reason"`
+	}
+
+	err := json.Unmarshal(archivedAsBytes, &stored)
+	if err != nil {
+		return archived, false, newError(ErrInternal, "Stored archived trade is not a JSON object")
+	}
+
+	outdated := storedSchema(stored.Trade) != tradeSchemaVersion
+	archived.Trade, err = decodeTrade(stored.Trade)
+	if err != nil {
+		return archived, false, err
+	}
+	archived.Trade.Status = tradeStatus(archived.Trade)
+
+	archived.Versions = []Trade{}
+	for _, versionAsBytes := range stored.Versions {
+		if storedSchema(versionAsBytes) != tradeSchemaVersion {
+			outdated = true
+		}
+		version, err := decodeTrade(versionAsBytes)
+		if err != nil {
+			return archived, false, err
+		}
+		archived.Versions = append(archived.Versions, version)
+	}
+
+	archived.History = stored.History
+	archived.ArchivedAt = stored.ArchivedAt
+	archived.Reason = stored.Reason
+	return archived, outdated, nil
+}
+
+// ============================================================================================================================
+// migrateArchivedTrade - rewrite an archived trade whose trade or versions are stored under an older schema
+//                        returns how many records were rewritten, the archive keeps them all in one
+// ============================================================================================================================
+func migrateArchivedTrade(stub *shim.ChaincodeStub, archivedKey string, archivedAsBytes []byte) (int, error) {
+	archived, outdated, err := decodeArchivedTrade(archivedAsBytes)
+	if err != nil {
+		return 0, newError(ErrInternal, "Archived trade " + strings.TrimPrefix(archivedKey, archivedTradePrefix) + ": " + err.Error())
+	}
+	if !outdated {
+		return 0, nil
+	}
+
+	jsonAsBytes, _ := json.Marshal(archived)
+	err = stub.PutState(archivedKey, jsonAsBytes)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// ============================================================================================================================
+// backfillStoredTrade - fill in the fields trades booked before they existed lack, true if any was missing
+// ============================================================================================================================
+func backfillStoredTrade(stub *shim.ChaincodeStub, key string, trade *Trade) (bool, error) {
+	if trade.ID != "" && trade.Trader != "" && trade.Version != 0 && trade.Consideration != "" {
+		return false, nil
+	}
+
+	trade.Version = tradeVersion(*trade)
+
+	err := backfillTrade(stub, key, trade)
+	if err != nil {
+		return false, newError(errorCode(err), "Trade " + key + " cannot be priced: " + err.Error())
+	}
+	return true, nil
+}
+
+// ============================================================================================================================
+// migrateVersions - rewrite the superseded versions of a trade stored under an older schema, returns how many were
+// ============================================================================================================================
+func migrateVersions(stub *shim.ChaincodeStub, key string, trade Trade) (int, error) {
+	migrated := 0
+	for v := 1; v < tradeVersion(trade); v++ {
+		versionAsBytes, err := stub.GetState(tradeVersionKey(key, v))
+		if err != nil {
+			return migrated, newError(ErrInternal, "Failed to get version " + strconv.Itoa(v) + " of trade " + key)
+		}
+		if len(versionAsBytes) == 0 || storedSchema(versionAsBytes) == tradeSchemaVersion {
+			continue
+		}
+
+		version, err := decodeTrade(versionAsBytes)
+		if err != nil {
+			return migrated, err
+		}
+		err = putTrade(stub, tradeVersionKey(key, v), version)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// ============================================================================================================================
+// migrate_records - rewrite active, cancelled and archived trades and their superseded versions to the current schema
+//                   one batch per call, pass the returned bookmark to continue, records already current are left alone
+//                   trades indexed while a migration runs can shift the index, run it again until it migrates nothing
+// ============================================================================================================================
+func (t *SimpleChaincode) migrate_records(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//      0           1
+	// "batch size", "bookmark"
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(ErrInvalidArgs, "Incorrect number of arguments. Expecting 1 or 2, batch size and an optional bookmark")
+	}
+
+	fmt.Println("- start migrate_records")
+
+	batchSize, err := strconv.Atoi(args[0])
+	if err != nil || batchSize < 1 || batchSize > maxPageSize {
+		return nil, argError(ErrInvalidArgs, 0, "1st argument must be a batch size between 1 and " + strconv.Itoa(maxPageSize))
+	}
+
+	tradeIndex, err := getIndex(stub, tradeIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	cancelledIndex, err := getIndex(stub, cancelledTradeIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	archivedIndex, err := getIndex(stub, archivedTradeIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	keys := append(tradeIndex, cancelledIndex...)
+	for _, key := range archivedIndex {
+		keys = append(keys, archivedTradePrefix + key)				// the archived record, not the trade key it was under
+	}
+
+	bookmark := ""
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+	start, err := decodeBookmark(bookmark, keys)
+	if err != nil {
+		return nil, argError(ErrInvalidArgs, 1, err.Error())
+	}
+
+	report := MigrationReport{}
+	i := start
+	for ; i < len(keys) && i < start + batchSize; i++ {
+		key := keys[i]
+		tradeAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, newError(ErrInternal, "Failed to get value for key " + key)
+		}
+		report.Checked++
+		if len(tradeAsBytes) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(key, archivedTradePrefix) {
+			migrated, err := migrateArchivedTrade(stub, key, tradeAsBytes)
+			if err != nil {
+				return nil, err
+			}
+			report.Migrated += migrated
+			continue
+		}
+
+		trade, err := getTrade(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		backfilled, err := backfillStoredTrade(stub, key, &trade)
+		if err != nil {
+			return nil, err
+		}
+		if backfilled || storedSchema(tradeAsBytes) != tradeSchemaVersion {
+			err = putTrade(stub, key, trade)								// getTrade also filled in a missing status
+			if err != nil {
+				return nil, err
+			}
+			report.Migrated++
+		}
+
+		migrated, err := migrateVersions(stub, key, trade)
+		if err != nil {
+			return nil, err
+		}
+		report.Migrated += migrated
+	}
+	if i < len(keys) {
+		report.Bookmark = encodeBookmark(i - 1, keys[i - 1])
+	}
+
+	fmt.Println("- end migrate_records")
+	return json.Marshal(report)
+}
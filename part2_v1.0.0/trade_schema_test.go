@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeTrade(t *testing.T) {
+	tests := []struct {
+		stored string
+		quantity int
+		timestamp string
+		status string
+	}{
+		// version 1 written through ,string tags
+		{`{"operation":"buy","quantity":"10","timestamp":"123","settled":"0","needsrevision":"0"}`, 10, "123", StatusSubmitted},
+		// version 1 written by hand, part2 stored the timestamp as a number
+		{`{"operation":"sell","quantity":7,"timestamp":1476000000000,"settled":1,"needsrevision":0}`, 7, "1476000000000", StatusSettled},
+		// version 2
+		{`{"schema":2,"operation":"buy","quantity":"3","timestamp":"9","settled":"0","needsrevision":"1","status":"needs_revision"}`, 3, "9", StatusNeedsRevision},
+	}
+	for _, test := range tests {
+		trade, err := decodeTrade([]byte(test.stored))
+		if err != nil {
+			t.Errorf("%s: %v", test.stored, err)
+			continue
+		}
+		if trade.Schema != tradeSchemaVersion || trade.Quantity != test.quantity || trade.Timestamp != test.timestamp || tradeStatus(trade) != test.status {
+			t.Errorf("%s: decoded as %+v", test.stored, trade)
+		}
+	}
+
+	_, err := decodeTrade([]byte(`{"schema":99}`))
+	if errorCode(err) != ErrInternal {
+		t.Errorf("newer schema decoded, got %v", err)
+	}
+}
+
+func TestDecodeArchivedTrade(t *testing.T) {
+	stored := `{"trade":{"quantity":10,"settled":1,"needsrevision":0,"version":2},"history":[],` +
+		`"versions":[{"quantity":4,"settled":0,"needsrevision":0,"version":1}],"archivedat":"2016-02-01T00:00:00Z","reason":"cleared"}`
+	archived, outdated, err := decodeArchivedTrade([]byte(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outdated {
+		t.Error("version 1 archive not reported as outdated")
+	}
+	if archived.Trade.Quantity != 10 || archived.Trade.Status != StatusSettled || archived.Trade.Schema != tradeSchemaVersion {
+		t.Errorf("archived trade decoded as %+v", archived.Trade)
+	}
+	if len(archived.Versions) != 1 || archived.Versions[0].Quantity != 4 || archived.Versions[0].Schema != tradeSchemaVersion {
+		t.Errorf("archived versions decoded as %+v", archived.Versions)
+	}
+	if archived.ArchivedAt != "2016-02-01T00:00:00Z" || archived.Reason != "cleared" {
+		t.Errorf("archive details decoded as %+v", archived)
+	}
+
+	current := `{"trade":{"schema":2,"quantity":"10","settled":"1","needsrevision":"0"},"history":[],"versions":[]}`
+	_, outdated, err = decodeArchivedTrade([]byte(current))
+	if err != nil || outdated {
+		t.Errorf("current archive reported as outdated, %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// Trade lifecycle states, stored in Trade.Status
+const (
+	StatusSubmitted     = "submitted"			// booked by the trader, waiting for middle office
+	StatusNeedsRevision = "needs_revision"		// returned to the trader for revision
+	StatusRevised       = "revised"				// trader has revised the trade
+	StatusEnriched      = "enriched"			// settlement details added
+	StatusSettled       = "settled"				// settled, no further changes allowed
+	StatusCancelled     = "cancelled"			// cancelled before settlement
+	StatusFailed        = "failed"				// settlement failed
+)
+
+// tradeTransitions lists, for every state, the states a trade may move to next
+var tradeTransitions = map[string][]string{
+	StatusSubmitted:     {StatusNeedsRevision, StatusEnriched, StatusCancelled, StatusFailed},
+	StatusNeedsRevision: {StatusRevised, StatusCancelled, StatusFailed},
+	StatusRevised:       {StatusNeedsRevision, StatusEnriched, StatusCancelled, StatusFailed},
+	StatusEnriched:      {StatusNeedsRevision, StatusSettled, StatusCancelled, StatusFailed},
+	StatusSettled:       {StatusCancelled},		// only by cancel_trade, which books a reversing trade
+	StatusCancelled:     {},
+	StatusFailed:        {},
+}
+
+// ============================================================================================================================
+// canTransition - true if the transition table allows a trade to move from one state to another
+// ============================================================================================================================
+func canTransition(from string, to string) bool {
+	for _, next := range tradeTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// transitionTrade - move a trade to a new state, keeping the legacy settled/needsrevision flags in step
+// ============================================================================================================================
+func transitionTrade(trade *Trade, to string) error {
+	from := tradeStatus(*trade)
+	if !canTransition(from, to) {
+		return newError(ErrIllegalTransition, "Illegal trade transition from " + from + " to " + to)
+	}
+	if to == StatusSettled {
+		err := validateEnrichment(trade.Enrichment)					// nothing settles without settlement details
+		if err != nil {
+			return err
+		}
+	}
+
+	trade.Status = to
+	trade.Settled = 0
+	trade.NeedsRevision = 0
+	if to == StatusSettled {
+		trade.Settled = 1
+	} else if to == StatusNeedsRevision {
+		trade.NeedsRevision = 1
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// tradeStatus - current state of a trade, mapping records written before Status existed from their settled/needsrevision flags
+// ============================================================================================================================
+func tradeStatus(trade Trade) string {
+	if trade.Status != "" {
+		return trade.Status
+	}
+	if trade.Settled == 1 {
+		return StatusSettled
+	}
+	if trade.NeedsRevision == 1 {
+		return StatusNeedsRevision
+	}
+	return StatusSubmitted
+}